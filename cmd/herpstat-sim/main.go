@@ -0,0 +1,103 @@
+// Package main runs a fake Herpstat SpyderWeb for local development.
+//
+// You can find all relevant code in [github.com/jjack/herpstat_spyderweb_exporter/simulator]
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+)
+
+const httpReadTimeout = 12 * time.Second
+
+var (
+	listenAddress = kingpin.Flag(
+		"listen-address",
+		"Address on which to serve /RAWSTATUS.",
+	).Default(":8080").String()
+	nickname = kingpin.Flag(
+		"nickname",
+		"The simulated device's nickname.",
+	).Default("Herpstat Simulator").String()
+	outputs = kingpin.Flag(
+		"outputs",
+		"Number of outputs.",
+	).Default("4").Int()
+	drift = kingpin.Flag(
+		"drift",
+		"The most that any reading can change between polls.",
+	).Default("0.5").Float64()
+	invalidJSONRate = kingpin.Flag(
+		"invalid-json-rate",
+		"Chance (0-1) that any response comes back as invalid JSON.",
+	).Default("0").Float64()
+	delay = kingpin.Flag(
+		"delay",
+		"How long to wait before responding.",
+	).Default("0s").Duration()
+	unplugged = kingpin.Flag(
+		"unplug",
+		"Output number whose probe is unplugged. Can be given more than once.",
+	).Ints()
+	tripRelayAfter = kingpin.Flag(
+		"trip-relay-after",
+		"Trip the safety relay after this long. 0 never trips it.",
+	).Default("0s").Duration()
+)
+
+func main() {
+	kingpin.Parse()
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
+	logger = log.With(logger, "ts", log.DefaultTimestamp)
+	logger = level.NewFilter(logger, level.AllowInfo())
+
+	if *outputs < 1 {
+		level.Error(logger).Log("msg", fmt.Sprintf("--outputs=%d needs to be at least 1", *outputs))
+		os.Exit(1)
+	}
+
+	device := simulator.New(simulator.Config{
+		Nickname:        *nickname,
+		Outputs:         *outputs,
+		Drift:           *drift,
+		InvalidJSONRate: *invalidJSONRate,
+		Delay:           *delay,
+		Seed:            time.Now().UnixNano(),
+	})
+
+	for _, id := range *unplugged {
+		if id < 1 || id > *outputs {
+			level.Error(logger).Log("msg", fmt.Sprintf("--unplug=%d isn't a valid output number", id))
+			os.Exit(1)
+		}
+
+		device.UnplugProbe(id)
+	}
+
+	if *tripRelayAfter > 0 {
+		time.AfterFunc(*tripRelayAfter, func() {
+			level.Info(logger).Log("msg", "Tripping safety relay")
+			device.TripSafetyRelay("")
+		})
+	}
+
+	level.Info(logger).Log("msg", "Serving a simulated Herpstat SpyderWeb", "url", fmt.Sprintf("http://%s%s", *listenAddress, simulator.RawstatusPath))
+
+	server := &http.Server{
+		Addr:        *listenAddress,
+		Handler:     device,
+		ReadTimeout: httpReadTimeout,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
+	}
+}
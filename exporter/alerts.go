@@ -0,0 +1,356 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+	alertStatusUnknown  = "unknown"
+
+	// how often firing alerts are checked for devices that have stopped reporting
+	alertStaleCheckInterval = 10 * time.Second
+)
+
+// alerter is an optional, in-process alert rule evaluator for people who don't want to run a whole Alertmanager. It
+// listens for every successful poll (see [exporter.pollListener]), checks each [exporter.alertRule] against the
+// polled outputs and sends a [exporter.notification] to every webhook whenever an alert starts or stops firing.
+type alerter struct {
+	sync.Mutex
+
+	rules    []*alertRule
+	webhooks []*webhookConfig
+	client   *http.Client
+	alerts   map[string]*alertState
+}
+
+// alertState keeps track of a single rule against a single output
+type alertState struct {
+	pendingSince time.Time
+	firing       bool
+	direction    string
+
+	// fired is the notification that was sent when the alert started firing, which is reused if its readings go
+	// missing. lastSeen is when the last valid reading was checked, and maxAge is how long that can go on for.
+	fired    *notification
+	lastSeen time.Time
+	maxAge   time.Duration
+}
+
+// notification is the JSON body POSTed to each webhook
+type notification struct {
+	Status     string    `json:"status"`
+	Rule       string    `json:"rule"`
+	System     string    `json:"system"`
+	Address    string    `json:"address"`
+	Output     string    `json:"output"`
+	OutputName string    `json:"output_name"`
+	Field      string    `json:"field"`
+	Unit       string    `json:"unit"`
+	Direction  string    `json:"direction"`
+	Value      float64   `json:"value"`
+	Threshold  float64   `json:"threshold"`
+	StartsAt   time.Time `json:"starts_at"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func newAlerter(c *alertsConfig) *alerter {
+	return &alerter{
+		rules:    c.Rules,
+		webhooks: c.Webhooks,
+		client:   &http.Client{},
+		alerts:   map[string]*alertState{},
+	}
+}
+
+// polled evaluates every rule against every matching output. It implements [exporter.pollListener].
+func (a *alerter) polled(device *deviceConfig, info *info, at time.Time) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, rule := range a.rules {
+		if !rule.matchesDevice(device) {
+			continue
+		}
+
+		for i := range *info.outputs {
+			o := &(*info.outputs)[i]
+			if !rule.matchesOutput(o) {
+				continue
+			}
+
+			a.evaluate(rule, device, info.system, o, at)
+		}
+	}
+}
+
+// run checks for firing alerts whose device has stopped reporting until the context is cancelled
+func (a *alerter) run(ctx context.Context) {
+	ticker := time.NewTicker(alertStaleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.checkStale(now)
+		}
+	}
+}
+
+// checkStale sends an unknown notification for every firing alert that hasn't had a valid reading for longer than
+// its device's max_staleness (or 5 poll intervals, if that's disabled)
+func (a *alerter) checkStale(now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, state := range a.alerts {
+		if state.firing && now.Sub(state.lastSeen) > state.maxAge {
+			a.unknown(state, now)
+		}
+	}
+}
+
+// unknown stops an alert from firing because its reading has gone missing, and says so. It starts over once there's
+// a valid reading again.
+func (a *alerter) unknown(state *alertState, at time.Time) {
+	n := *state.fired
+	n.Status = alertStatusUnknown
+	n.Timestamp = at
+
+	state.firing = false
+	state.pendingSince = time.Time{}
+
+	a.notify(&n)
+}
+
+// evaluate checks a single rule against a single output. An alert only fires once its threshold has been crossed
+// for at least [exporter.alertRule.For], and it only resolves once the reading is back within the threshold by at
+// least [exporter.alertRule.Hysteresis], which keeps readings hovering around a threshold from flapping. A firing
+// alert whose reading becomes invalid (such as a pulled probe) goes to unknown instead.
+func (a *alerter) evaluate(rule *alertRule, device *deviceConfig, s *system, o *output, at time.Time) {
+	key := fmt.Sprintf("%s/%s/%s", rule.Name, device.Address, o.ID)
+
+	state, ok := a.alerts[key]
+	if !ok {
+		state = &alertState{}
+		a.alerts[key] = state
+	}
+
+	value, ok := rule.value(o)
+	if !ok {
+		if state.firing {
+			a.unknown(state, at)
+		}
+
+		state.pendingSince = time.Time{}
+
+		return
+	}
+
+	state.lastSeen = at
+	state.maxAge = maxTrackingGap(device)
+
+	high, low := rule.thresholds(o)
+
+	if state.firing {
+		if !rule.resolved(value, high, low) {
+			return
+		}
+
+		n := rule.notification(alertStatusResolved, state, device, s, o, value, at)
+
+		state.firing = false
+		state.pendingSince = time.Time{}
+
+		a.notify(n)
+
+		return
+	}
+
+	direction := rule.breached(value, high, low)
+	if direction == "" {
+		state.pendingSince = time.Time{}
+		return
+	}
+
+	if state.pendingSince.IsZero() || state.direction != direction {
+		state.pendingSince = at
+		state.direction = direction
+	}
+
+	if at.Sub(state.pendingSince) < rule.For {
+		return
+	}
+
+	state.firing = true
+	state.fired = rule.notification(alertStatusFiring, state, device, s, o, value, at)
+
+	a.notify(state.fired)
+}
+
+// notify sends a notification to every webhook in the background
+func (a *alerter) notify(n *notification) {
+	level.Info(logger).Log("msg", "Alert "+n.Status, "rule", n.Rule, "system", n.System, "output", n.Output, "value", n.Value)
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		level.Error(logger).Log("msg", "unable to marshal notification", "err", err)
+		return
+	}
+
+	for _, w := range a.webhooks {
+		go a.send(w, body)
+	}
+}
+
+// send POSTs a notification to a webhook, retrying up to [exporter.webhookConfig.Retries] times
+func (a *alerter) send(w *webhookConfig, body []byte) {
+	for i := 0; i <= w.Retries; i++ {
+		if i > 0 {
+			time.Sleep(w.RetryWait)
+		}
+
+		err := a.post(w, body)
+		if err == nil {
+			return
+		}
+
+		level.Warn(logger).Log("msg", fmt.Sprintf("unable to send notification (attempt %d/%d)", i+1, w.Retries+1), "url", w.URL, "err", err)
+	}
+
+	level.Error(logger).Log("msg", "giving up on sending notification", "url", w.URL)
+}
+
+func (a *alerter) post(w *webhookConfig, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (r *alertRule) matchesDevice(d *deviceConfig) bool {
+	return r.Device == "" || r.Device == d.Address || (d.Name != "" && r.Device == d.Name)
+}
+
+func (r *alertRule) matchesOutput(o *output) bool {
+	return r.Output == "" || r.Output == o.ID || r.Output == o.Name
+}
+
+// value returns the reading that this rule checks, in Celsius for temperatures. Readings that look like a pulled
+// probe, that come from an output whose mode doesn't have that kind of probe, or that can't be converted to Celsius
+// yet are ignored.
+func (r *alertRule) value(o *output) (float64, bool) {
+	if r.Field == alertFieldHumidity {
+		return o.ProbeHumidity, o.mode.hasHumidityProbe() && o.invalid.valid(fieldHumidity)
+	}
+
+	return o.unit.toCelsius(o.ProbeTemp), o.mode.hasTemperatureProbe() && o.unit.known() && o.invalid.valid(fieldTemperature)
+}
+
+// unit returns the unit of this rule's readings and thresholds
+func (r *alertRule) unit() string {
+	if r.Field == alertFieldHumidity {
+		return unitPercent
+	}
+
+	return string(unitCelsius)
+}
+
+// thresholds returns the high and low thresholds for an output. nil means that there isn't one. The device's own
+// alarm settings are converted to Celsius, so they're only used once the output's unit is known.
+func (r *alertRule) thresholds(o *output) (high, low *float64) {
+	high, low = r.Above, r.Below
+
+	if r.DeviceAlarm && o.AlarmEnabled == 1 && o.hasKnownUnit() {
+		if high == nil {
+			high = float64Ptr(o.setting(o.AlarmHigh))
+		}
+
+		if low == nil {
+			low = float64Ptr(o.setting(o.AlarmLow))
+		}
+	}
+
+	return high, low
+}
+
+// breached returns which threshold a value is past, if any
+func (r *alertRule) breached(value float64, high, low *float64) string {
+	if high != nil && value > *high {
+		return alarmDirectionHigh
+	}
+
+	if low != nil && value < *low {
+		return alarmDirectionLow
+	}
+
+	return ""
+}
+
+// resolved checks whether a value is back within both thresholds, taking the hysteresis into account
+func (r *alertRule) resolved(value float64, high, low *float64) bool {
+	if high != nil && value > *high-r.Hysteresis {
+		return false
+	}
+
+	if low != nil && value < *low+r.Hysteresis {
+		return false
+	}
+
+	return true
+}
+
+func (r *alertRule) notification(status string, state *alertState, d *deviceConfig, s *system, o *output, value float64, at time.Time) *notification {
+	high, low := r.thresholds(o)
+
+	threshold := 0.0
+	if state.direction == alarmDirectionHigh && high != nil {
+		threshold = *high
+	} else if state.direction == alarmDirectionLow && low != nil {
+		threshold = *low
+	}
+
+	return &notification{
+		Status:     status,
+		Rule:       r.Name,
+		System:     s.Name,
+		Address:    d.Address,
+		Output:     o.ID,
+		OutputName: o.Name,
+		Field:      r.Field,
+		Unit:       r.unit(),
+		Direction:  state.direction,
+		Value:      value,
+		Threshold:  threshold,
+		StartsAt:   state.pendingSince,
+		Timestamp:  at,
+	}
+}
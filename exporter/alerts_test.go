@@ -0,0 +1,327 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlertRuleThresholds(t *testing.T) {
+	tests := []struct {
+		name         string
+		rule         alertRule
+		unit         temperatureUnit
+		alarmEnabled float64
+		high, low    *float64
+	}{
+		{"above", alertRule{Above: float64Ptr(90)}, unitCelsius, 1, float64Ptr(90), nil},
+		{"below", alertRule{Below: float64Ptr(85)}, unitCelsius, 1, nil, float64Ptr(85)},
+		{"device alarm", alertRule{DeviceAlarm: true}, unitCelsius, 1, float64Ptr(95), float64Ptr(80)},
+		{"device alarm disabled", alertRule{DeviceAlarm: true}, unitCelsius, 0, nil, nil},
+		// explicit thresholds take precedence over the device's own alarm
+		{"device alarm with above", alertRule{DeviceAlarm: true, Above: float64Ptr(90)}, unitCelsius, 1, float64Ptr(90), float64Ptr(80)},
+		// the device's own alarm is converted to Celsius, like the rule's thresholds
+		{"fahrenheit device alarm", alertRule{DeviceAlarm: true}, unitFahrenheit, 1, float64Ptr(35), float64Ptr((80 - 32.0) * 5 / 9)},
+		// and isn't used at all until the unit is known
+		{"unknown unit device alarm", alertRule{DeviceAlarm: true}, unitUnknown, 1, nil, nil},
+	}
+
+	for _, tt := range tests {
+		high, low := tt.rule.thresholds(&output{mode: modeHeating, unit: tt.unit, ProbeTemp: 88, AlarmEnabled: tt.alarmEnabled, AlarmHigh: 95, AlarmLow: 80})
+
+		if !equalFloatPtr(high, tt.high) || !equalFloatPtr(low, tt.low) {
+			t.Errorf("%s: expected %v/%v, got %v/%v", tt.name, formatFloatPtr(tt.high), formatFloatPtr(tt.low), formatFloatPtr(high), formatFloatPtr(low))
+		}
+	}
+}
+
+func TestAlertRuleBreachedAndResolved(t *testing.T) {
+	rule := &alertRule{Hysteresis: 2}
+	high, low := float64Ptr(95), float64Ptr(80)
+
+	tests := []struct {
+		value     float64
+		high, low *float64
+		breached  string
+		resolved  bool
+	}{
+		{88, high, low, "", true},
+		{95, high, low, "", false},
+		{96, high, low, alarmDirectionHigh, false},
+		{79, high, low, alarmDirectionLow, false},
+		// back within the thresholds, but not by the hysteresis
+		{94, high, low, "", false},
+		{81, high, low, "", false},
+		{93, high, low, "", true},
+		{82, high, low, "", true},
+		{1000, high, nil, alarmDirectionHigh, false},
+		{1000, nil, low, "", true},
+		{1000, nil, nil, "", true},
+	}
+
+	for _, tt := range tests {
+		if got := rule.breached(tt.value, tt.high, tt.low); got != tt.breached {
+			t.Errorf("%v: expected breached to be %q, got %q", tt.value, tt.breached, got)
+		}
+
+		if got := rule.resolved(tt.value, tt.high, tt.low); got != tt.resolved {
+			t.Errorf("%v: expected resolved to be %v, got %v", tt.value, tt.resolved, got)
+		}
+	}
+}
+
+func TestAlertRuleValue(t *testing.T) {
+	celsius := &output{ID: "1", mode: modeHeating, unit: unitCelsius, ProbeTemp: 88}
+	fahrenheit := &output{ID: "1", mode: modeHeating, unit: unitFahrenheit, ProbeTemp: 95}
+	humidity := &output{ID: "2", mode: modeHumidity, ProbeTemp: 80, ProbeHumidity: 65}
+	pulled := &output{ID: "3", mode: modeHeating, ProbeTemp: 6553.5, invalid: fieldSet{fieldTemperature: true}}
+	timer := &output{ID: "4", mode: modeTimer, ProbeTemp: 80}
+	unknown := &output{ID: "5", mode: modeHeating, ProbeTemp: 80}
+
+	tests := []struct {
+		field string
+		o     *output
+		value float64
+		ok    bool
+	}{
+		{alertFieldTemperature, celsius, 88, true},
+		{alertFieldTemperature, fahrenheit, 35, true},
+		{alertFieldTemperature, unknown, 0, false},
+		{alertFieldHumidity, humidity, 65, true},
+		{alertFieldTemperature, humidity, 0, false},
+		{alertFieldTemperature, pulled, 0, false},
+		{alertFieldTemperature, timer, 0, false},
+	}
+
+	for _, tt := range tests {
+		value, ok := (&alertRule{Field: tt.field}).value(tt.o)
+
+		if ok != tt.ok || (ok && value != tt.value) {
+			t.Errorf("%s on output %s: expected %v (ok: %v), got %v (ok: %v)", tt.field, tt.o.ID, tt.value, tt.ok, value, ok)
+		}
+	}
+}
+
+// webhookRecorder is a webhook that remembers every notification that it receives, failing the first few requests
+type webhookRecorder struct {
+	sync.Mutex
+
+	failures      int
+	requests      int
+	notifications chan notification
+}
+
+func newWebhookRecorder(t *testing.T, failures int) (*webhookRecorder, *webhookConfig) {
+	w := &webhookRecorder{failures: failures, notifications: make(chan notification, 10)}
+
+	server := httptest.NewServer(w)
+	t.Cleanup(server.Close)
+
+	return w, &webhookConfig{URL: server.URL, Retries: 3, RetryWait: time.Millisecond, Timeout: time.Second}
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.requests++
+
+	if w.requests <= w.failures {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var n notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.notifications <- n
+}
+
+func (w *webhookRecorder) count() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.requests
+}
+
+// next waits for the next notification, or fails if there isn't one
+func (w *webhookRecorder) next(t *testing.T) notification {
+	t.Helper()
+
+	select {
+	case n := <-w.notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a notification")
+		return notification{}
+	}
+}
+
+func TestAlerterEvaluate(t *testing.T) {
+	recorder, webhook := newWebhookRecorder(t, 0)
+	rule := &alertRule{Name: "too-hot", Field: alertFieldTemperature, Above: float64Ptr(95), For: time.Minute, Hysteresis: 2}
+	a := newAlerter(&alertsConfig{Rules: []*alertRule{rule}, Webhooks: []*webhookConfig{webhook}})
+
+	device := newDeviceConfig("1.2.3.4")
+	s := system{Name: "Reptile Room"}
+	start := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		after  time.Duration
+		value  float64
+		status string
+	}{
+		{0, 96, ""},
+		// dipping back below the threshold starts the wait over
+		{30 * time.Second, 94, ""},
+		{40 * time.Second, 97, ""},
+		{90 * time.Second, 97, ""},
+		{100 * time.Second, 98, alertStatusFiring},
+		{110 * time.Second, 99, ""},
+		// still within the hysteresis
+		{120 * time.Second, 94, ""},
+		{130 * time.Second, 92, alertStatusResolved},
+		{140 * time.Second, 92, ""},
+	}
+
+	for _, tt := range tests {
+		a.polled(device, newTestInfo(s, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: tt.value}), start.Add(tt.after))
+
+		if tt.status == "" {
+			continue
+		}
+
+		n := recorder.next(t)
+		if n.Status != tt.status || n.Value != tt.value || n.Unit != string(unitCelsius) || n.Direction != alarmDirectionHigh || n.Threshold != 95 || !n.StartsAt.Equal(start.Add(40*time.Second)) {
+			t.Errorf("%s: expected %s at %v, got %+v", tt.after, tt.status, tt.value, n)
+		}
+	}
+
+	select {
+	case n := <-recorder.notifications:
+		t.Errorf("expected no more notifications, got %+v", n)
+	default:
+	}
+}
+
+func TestAlerterDeviceAlarm(t *testing.T) {
+	recorder, webhook := newWebhookRecorder(t, 0)
+	rule := &alertRule{Name: "device-alarm", Output: "Basking Spot", Field: alertFieldTemperature, DeviceAlarm: true}
+	a := newAlerter(&alertsConfig{Rules: []*alertRule{rule}, Webhooks: []*webhookConfig{webhook}})
+
+	device := newDeviceConfig("1.2.3.4")
+	now := time.Now()
+
+	// an output whose alarm is disabled doesn't have any thresholds
+	a.polled(device, newTestInfo(system{}, output{Name: "Basking Spot", mode: modeHeating, unit: unitCelsius, ProbeTemp: 70, AlarmHigh: 95, AlarmLow: 80}), now)
+	a.polled(device, newTestInfo(system{}, output{Name: "Basking Spot", mode: modeHeating, unit: unitCelsius, ProbeTemp: 70, AlarmEnabled: 1, AlarmHigh: 95, AlarmLow: 80}), now)
+
+	if n := recorder.next(t); n.Status != alertStatusFiring || n.Direction != alarmDirectionLow || n.Threshold != 80 || n.OutputName != "Basking Spot" {
+		t.Errorf("expected the device's low alarm to fire, got %+v", n)
+	}
+
+	if got := recorder.count(); got != 1 {
+		t.Errorf("expected 1 notification, got %d", got)
+	}
+}
+
+func TestAlerterUnknown(t *testing.T) {
+	recorder, webhook := newWebhookRecorder(t, 0)
+	rule := &alertRule{Name: "too-hot", Field: alertFieldTemperature, Above: float64Ptr(95)}
+	a := newAlerter(&alertsConfig{Rules: []*alertRule{rule}, Webhooks: []*webhookConfig{webhook}})
+
+	device := newDeviceConfig("1.2.3.4")
+	s := system{Name: "Reptile Room"}
+	start := time.Unix(1700000000, 0)
+
+	pulled := output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 6553.5, invalid: fieldSet{fieldTemperature: true}}
+
+	// a pulled probe stops the alert from firing, and it starts over once the probe is back
+	tests := []struct {
+		after  time.Duration
+		o      output
+		status string
+	}{
+		{0, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 96}, alertStatusFiring},
+		{10 * time.Second, pulled, alertStatusUnknown},
+		{20 * time.Second, pulled, ""},
+		{30 * time.Second, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 97}, alertStatusFiring},
+	}
+
+	for _, tt := range tests {
+		a.polled(device, newTestInfo(s, tt.o), start.Add(tt.after))
+
+		if tt.status == "" {
+			continue
+		}
+
+		if n := recorder.next(t); n.Status != tt.status {
+			t.Errorf("%s: expected %s, got %+v", tt.after, tt.status, n)
+		}
+	}
+
+	// and so does a device that stops reporting altogether
+	maxAge := maxTrackingGap(device)
+
+	a.checkStale(start.Add(30 * time.Second).Add(maxAge))
+
+	select {
+	case n := <-recorder.notifications:
+		t.Errorf("expected the alert to keep firing until max_staleness, got %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.checkStale(start.Add(31 * time.Second).Add(maxAge))
+	a.checkStale(start.Add(41 * time.Second).Add(maxAge))
+
+	if n := recorder.next(t); n.Status != alertStatusUnknown || n.Value != 97 {
+		t.Errorf("expected the stale alert to be unknown, got %+v", n)
+	}
+
+	if got := recorder.count(); got != 4 {
+		t.Errorf("expected 4 notifications, got %d", got)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	recorder, webhook := newWebhookRecorder(t, 2)
+	a := newAlerter(&alertsConfig{})
+
+	a.send(webhook, []byte(`{"status":"resolved"}`))
+
+	if n := recorder.next(t); n.Status != alertStatusResolved {
+		t.Errorf("expected the notification to be delivered, got %+v", n)
+	}
+
+	if got := recorder.count(); got != 3 {
+		t.Errorf("expected 2 failures and a success, got %d requests", got)
+	}
+
+	// giving up after the retries run out
+	recorder, webhook = newWebhookRecorder(t, 100)
+	webhook.Retries = 1
+
+	a.send(webhook, []byte(`{"status":"firing"}`))
+
+	if got := recorder.count(); got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+}
+
+func equalFloatPtr(a, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func formatFloatPtr(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+
+	return *f
+}
@@ -0,0 +1,23 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/log/level"
+)
+
+// writeJSON sends a JSON response to one of the /api/v1 endpoints
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		level.Error(logger).Log("msg", "unable to write response", "err", err)
+	}
+}
+
+// writeJSONError sends an error from one of the /api/v1 endpoints as {"error": "..."}
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -1,76 +1,240 @@
 package exporter
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-const (
-	minPower       = 0
-	minTemperature = 0
-	minHumidity    = 0
-	maxPower       = 100
-	maxTemperature = 212
-	maxHumidity    = 100
-)
-
-// Describes all of the metric types that we're exporting.
+// Describe doesn't send anything, which makes every [exporter.Exporter] an unchecked collector. Devices without any
+// extra labels all share the same descriptors, so they couldn't otherwise be registered together under /metrics.
 // Declaring this (along with [exporter.Collect]) implements a [prometheus.Collector]
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- e.metrics.info
-	ch <- e.metrics.temp
-	ch <- e.metrics.resets
-	ch <- e.metrics.outputPower
-	ch <- e.metrics.outputPowerLimit
-	ch <- e.metrics.outputProbeTemp
-	ch <- e.metrics.outputProbeHumidity
-	ch <- e.metrics.outputAlarmEnabled
-	ch <- e.metrics.outputAlarmHigh
-	ch <- e.metrics.outputAlarmLow
-	ch <- e.metrics.outputRamping
-	ch <- e.metrics.outputRampEnd
-	ch <- e.metrics.outputError
-}
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {}
 
 // Polls a Herpstat SpyderWeb, then sends the relevant data back to Prometheus via a channel.
 // Declaring this (along with [exporter.Describe]) implements a [prometheus.Collector].
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	level.Debug(logger).Log("msg", fmt.Sprintf("%s was called", *webTelemetryPath))
+	level.Debug(logger).Log("msg", "collecting metrics", "address", e.herpstat.device.Address)
 
-	if !e.herpstat.poll() {
+	if e.pollOnScrape && !e.herpstat.poll() {
 		level.Warn(logger).Log("msg", "Returning previously cached data.")
 	}
 
-	ch <- newCounterMetric(e.metrics.info, 1, e.herpstat.info.system.infoLabelValues()...)
+	info, lastPoll, up := e.herpstat.snapshot()
+	stale := e.herpstat.isStale(lastPoll)
+
+	if !up && !lastPoll.IsZero() {
+		e.herpstat.health.servedFromCache.Inc()
+	}
+
+	if up && !stale {
+		e.herpstat.health.up.Set(1)
+	} else {
+		e.herpstat.health.up.Set(0)
+	}
+
+	e.herpstat.health.collect(ch)
+
+	if lastPoll.IsZero() {
+		level.Warn(logger).Log("msg", "No data has been polled from this device yet.", "address", e.herpstat.device.Address)
+		return
+	}
+
+	ch <- newGaugeMetric(e.metrics.lastPoll, float64(lastPoll.UnixNano())/float64(time.Second), info.system.labelValues()...)
+	ch <- newGaugeMetric(e.metrics.pollAge, time.Since(lastPoll).Seconds(), info.system.labelValues()...)
+
+	ch <- newCounterMetric(e.metrics.info, 1, info.system.infoLabelValues()...)
+
+	// a dead device's last readings would otherwise show up as a flat, healthy-looking line forever
+	if stale {
+		level.Warn(logger).Log("msg", "Data is stale. Not exporting any readings.", "address", e.herpstat.device.Address, "age", time.Since(lastPoll))
+		return
+	}
+
+	if info.system.unit.known() {
+		ch <- newGaugeMetric(e.metrics.tempUnit, 1, info.system.unitLabelValues()...)
+	}
+
+	if info.system.invalid.valid(fieldInternalTemperature) {
+		ch <- newGaugeMetric(e.metrics.temp, info.system.unit.toCelsius(info.system.Temp), info.system.labelValues()...)
+	}
+	ch <- newGaugeMetric(e.metrics.safetyRelay, info.system.safetyrelay(), info.system.safetyRelayLabelValues()...)
+
+	relay := parseSafetyRelay(info.system.SafetyRelay)
+	for _, state := range safetyRelayStates {
+		ch <- newGaugeMetric(e.metrics.safetyRelayState, boolToFloat(relay == state), info.system.stateLabelValues(string(state))...)
+	}
+
+	ch <- newCounterMetric(e.metrics.safetyRelayTrips, e.herpstat.safetyRelayTripCount(), info.system.labelValues()...)
+
+	ch <- newCounterMetric(e.metrics.resets, info.system.PowerResets, info.system.labelValues()...)
 
-	if hasGoodValue(minTemperature, maxTemperature, e.herpstat.info.system.Temp) {
-		ch <- newGaugeMetric(e.metrics.temp, e.herpstat.info.system.Temp, e.herpstat.info.system.labelValues()...)
+	if lastReset := e.herpstat.lastResetTime(); !lastReset.IsZero() {
+		ch <- newGaugeMetric(e.metrics.lastReset, float64(lastReset.UnixNano())/float64(time.Second), info.system.labelValues()...)
 	}
-	ch <- newGaugeMetric(e.metrics.safetyRelay, e.herpstat.info.system.safetyrelay(), e.herpstat.info.system.safetyRelayLabelValues()...)
-	ch <- newGaugeMetric(e.metrics.resets, e.herpstat.info.system.PowerResets, e.herpstat.info.system.labelValues()...)
 
-	for i := range *e.herpstat.info.outputs {
-		output := &(*e.herpstat.info.outputs)[i]
-		systemName := e.herpstat.info.system.Name
+	for i := range *info.outputs {
+		output := &(*info.outputs)[i]
+		systemName := info.system.Name
 
 		ch <- newCounterMetric(e.metrics.outputInfo, 1, output.infoLabelValues(&systemName)...)
-		ch <- newGaugeMetric(e.metrics.outputPower, output.Power, output.labelValues(&systemName)...)
+
+		for _, mode := range outputModes {
+			ch <- newGaugeMetric(e.metrics.outputMode, boolToFloat(output.mode == mode), output.modeLabelValues(&systemName, mode)...)
+		}
+
+		if output.invalid.valid(fieldPower) {
+			ch <- newGaugeMetric(e.metrics.outputPower, output.Power, output.labelValues(&systemName)...)
+		}
 		ch <- newGaugeMetric(e.metrics.outputPowerLimit, output.PowerLimit, output.labelValues(&systemName)...)
 
-		if hasGoodValue(minTemperature, maxTemperature, output.ProbeTemp) {
-			ch <- newGaugeMetric(e.metrics.outputProbeTemp, output.ProbeTemp, output.labelValues(&systemName)...)
+		e.collectUsage(ch, output, &systemName)
+
+		// timers, lights, etc don't have a probe, so their readings and alarm/ramp settings are meaningless
+		if output.mode.hasTemperatureProbe() && output.invalid.valid(fieldTemperature) {
+			ch <- newGaugeMetric(e.metrics.outputProbeTemp, output.unit.toCelsius(output.ProbeTemp), output.labelValues(&systemName)...)
 		}
-		if hasGoodValue(minHumidity, maxHumidity, output.ProbeHumidity) {
+		if output.mode.hasHumidityProbe() && output.invalid.valid(fieldHumidity) {
 			ch <- newGaugeMetric(e.metrics.outputProbeHumidity, output.ProbeHumidity, output.labelValues(&systemName)...)
 		}
-		ch <- newGaugeMetric(e.metrics.outputAlarmEnabled, output.AlarmEnabled, output.labelValues(&systemName)...)
-		ch <- newGaugeMetric(e.metrics.outputAlarmHigh, output.AlarmHigh, output.labelValues(&systemName)...)
-		ch <- newGaugeMetric(e.metrics.outputAlarmLow, output.AlarmLow, output.labelValues(&systemName)...)
-		ch <- newGaugeMetric(e.metrics.outputRamping, output.ramping(), output.labelValues(&systemName)...)
-		ch <- newGaugeMetric(e.metrics.outputRampEnd, output.RampEnd, output.labelValues(&systemName)...)
+
+		if output.mode.hasProbe() {
+			fault := output.probeStatus()
+
+			ch <- newGaugeMetric(e.metrics.outputProbeConnected, boolToFloat(fault != probeFaultDisconnected), output.labelValues(&systemName)...)
+
+			for _, reason := range probeFaults {
+				ch <- newGaugeMetric(e.metrics.outputProbeFault, boolToFloat(fault == reason), output.probeFaultLabelValues(&systemName, reason)...)
+			}
+
+			ch <- newGaugeMetric(e.metrics.outputAlarmEnabled, output.AlarmEnabled, output.labelValues(&systemName)...)
+
+			// settings can't be converted to Celsius until the device's unit is known
+			if output.hasKnownUnit() {
+				ch <- newGaugeMetric(e.metrics.outputAlarmHigh, output.setting(output.AlarmHigh), output.labelValues(&systemName)...)
+				ch <- newGaugeMetric(e.metrics.outputAlarmLow, output.setting(output.AlarmLow), output.labelValues(&systemName)...)
+
+				// a missing endoframpsetting decodes to 0, which would otherwise be exported as -17.8°C
+				if output.RampEnd != 0 {
+					ch <- newGaugeMetric(e.metrics.outputRampEnd, output.setting(output.RampEnd), output.labelValues(&systemName)...)
+				}
+
+				for setting, value := range output.setpoints() {
+					if value != nil {
+						ch <- newGaugeMetric(e.metrics.outputSetpoint, output.setting(*value), output.setpointLabelValues(&systemName, setting)...)
+					}
+				}
+			}
+
+			reading, ok := output.probeReading()
+
+			if ok {
+				high, low := output.alarmActive(reading)
+
+				ch <- newGaugeMetric(e.metrics.outputAlarmActive, high, output.alarmLabelValues(&systemName, alarmDirectionHigh)...)
+				ch <- newGaugeMetric(e.metrics.outputAlarmActive, low, output.alarmLabelValues(&systemName, alarmDirectionLow)...)
+
+				if output.AlarmEnabled == 1 {
+					ch <- newGaugeMetric(e.metrics.outputAlarmMargin, output.alarmMargin(reading), output.labelValues(&systemName)...)
+				}
+			}
+
+			if ok && output.Setpoint != nil {
+				ch <- newGaugeMetric(e.metrics.outputControlError, output.controlError(reading), output.labelValues(&systemName)...)
+			}
+
+			ch <- newGaugeMetric(e.metrics.outputRamping, output.ramping(), output.labelValues(&systemName)...)
+
+			e.collectRamps(ch, output, &systemName)
+			e.collectSchedule(ch, output, &systemName)
+		}
+
 		ch <- newGaugeMetric(e.metrics.outputError, output.ErrorCode, output.errorLabelValues(&systemName)...)
+
+		active := errorCodes.lookup(output.ErrorCode, output.ErrorDesc)
+
+		for _, entry := range errorCodes.entries {
+			ch <- newGaugeMetric(e.metrics.outputErrorActive, boolToFloat(entry.code == active.code), output.errorActiveLabelValues(&systemName, entry)...)
+		}
+	}
+}
+
+// collectRamps sends everything that [exporter.rampTracker] knows about an output's ramp sessions
+func (e *Exporter) collectRamps(ch chan<- prometheus.Metric, output *output, systemName *string) {
+	state := parseRampState(output.Ramping)
+	for _, s := range rampStates {
+		ch <- newGaugeMetric(e.metrics.outputRampState, boolToFloat(state == s), output.rampStateLabelValues(systemName, s)...)
+	}
+
+	session, results, lastDuration := e.herpstat.ramps.status(output.ID)
+
+	for _, result := range rampResults {
+		ch <- newCounterMetric(e.metrics.outputRampSessions, results[result], output.rampResultLabelValues(systemName, result)...)
+	}
+
+	if lastDuration > 0 {
+		ch <- newGaugeMetric(e.metrics.outputRampDuration, lastDuration.Seconds(), output.labelValues(systemName)...)
+	}
+
+	if session == nil {
+		return
+	}
+
+	ch <- newGaugeMetric(e.metrics.outputRampStarted, float64(session.started.UnixNano())/float64(time.Second), output.labelValues(systemName)...)
+
+	if session.hasProgress {
+		ch <- newGaugeMetric(e.metrics.outputRampProgress, session.progress, output.labelValues(systemName)...)
+	}
+
+	if session.eta > 0 {
+		ch <- newGaugeMetric(e.metrics.outputRampETA, session.eta.Seconds(), output.labelValues(systemName)...)
+	}
+}
+
+// collectUsage sends an output's energy use and duty cycle from [exporter.usageTracker]. Energy is only exported for
+// outputs that have a configured wattage.
+func (e *Exporter) collectUsage(ch chan<- prometheus.Metric, output *output, systemName *string) {
+	usage, ok := e.herpstat.usage.status(output.ID)
+	if !ok {
+		return
+	}
+
+	if e.herpstat.usage.wattage(output.ID) > 0 {
+		ch <- newCounterMetric(e.metrics.outputEnergy, usage.EnergyJoules, output.labelValues(systemName)...)
+	}
+
+	now := time.Now()
+
+	for _, w := range dutyCycleWindows {
+		if ratio, ok := usage.dutyCycle(w.window, now); ok {
+			ch <- newGaugeMetric(e.metrics.outputDutyCycle, ratio, output.windowLabelValues(systemName, w.label)...)
+		}
+	}
+}
+
+// collectSchedule sends which phase of its day/night schedule an output is in, along with how well it has kept to
+// each phase's setpoint from [exporter.scheduleTracker]. Outputs without a schedule don't have either.
+func (e *Exporter) collectSchedule(ch chan<- prometheus.Metric, output *output, systemName *string) {
+	if phase, ok := output.schedulePhase(); ok {
+		for _, p := range schedulePhases {
+			ch <- newGaugeMetric(e.metrics.outputSchedulePhase, boolToFloat(phase == p), output.phaseLabelValues(systemName, p)...)
+		}
+	}
+
+	schedule, ok := e.herpstat.schedule.status(output.ID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	for _, phase := range schedulePhases {
+		for _, w := range dutyCycleWindows {
+			if ratio, ok := schedule.compliance(phase, w.window, now); ok {
+				ch <- newGaugeMetric(e.metrics.outputCompliance, ratio, output.phaseWindowLabelValues(systemName, phase, w.label)...)
+			}
+		}
 	}
 }
 
@@ -86,15 +250,11 @@ func newGaugeMetric(desc *prometheus.Desc, value float64, labelValues ...string)
 	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
 }
 
-// if a probe is pulled out in the middle of a poll, we'll get some extremely weird values.
-// this checks to see if any of those are out of some theoretically-typical ranges (eg: you aren't
-// going to heat something above boiling with this)
-func hasGoodValue(minWanted, maxWanted, value float64) bool {
-	if minWanted > value {
-		return false
-	} else if maxWanted < value {
-		return false
+// converts a bool into a 0/1 metric value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
 
-	return true
+	return 0
 }
@@ -0,0 +1,224 @@
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestCollectSetpoints uses a hand-written fixture, since the setpoint keys haven't been confirmed against a real
+// /RAWSTATUS response
+func TestCollectSetpoints(t *testing.T) {
+	e := newFixtureExporter(t, "rawstatus_setpoints.json")
+
+	expected := `
+# HELP herpstat_output_control_error Difference between the probe reading and the current setpoint. Celsius for temperature outputs and percent for humidity outputs.
+# TYPE herpstat_output_control_error gauge
+herpstat_output_control_error{output="1",system="Reptile Room"} -5
+herpstat_output_control_error{output="2",system="Reptile Room"} -3
+# HELP herpstat_output_setpoint Output target setting. Celsius for temperature outputs and percent for humidity outputs.
+# TYPE herpstat_output_setpoint gauge
+herpstat_output_setpoint{output="1",setting="current",system="Reptile Room"} 35
+herpstat_output_setpoint{output="1",setting="day",system="Reptile Room"} 35
+herpstat_output_setpoint{output="1",setting="night",system="Reptile Room"} 25
+herpstat_output_setpoint{output="1",setting="ramp_start",system="Reptile Room"} 30
+herpstat_output_setpoint{output="2",setting="current",system="Reptile Room"} 65
+`
+
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "herpstat_output_setpoint", "herpstat_output_control_error"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectWithoutSetpoints(t *testing.T) {
+	e := newExporter(newDeviceConfig(fixtureAddress))
+	e.herpstat.client.Transport = fixtureTransport(`{"system":{"numberofoutputs":1,"internaltemp":95},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86,"highalarm":95,"lowalarm":77}}`)
+
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll the fixture")
+	}
+
+	// a device that doesn't report the setpoint keys just doesn't have anything that depends on them
+	for _, name := range []string{"herpstat_output_setpoint", "herpstat_output_control_error", "herpstat_output_schedule_phase", "herpstat_output_schedule_compliance_ratio"} {
+		if n := testutil.CollectAndCount(e, name); n != 0 {
+			t.Errorf("expected no %s, got %d", name, n)
+		}
+	}
+
+	if n := testutil.CollectAndCount(e, "herpstat_output_alarm_high"); n != 1 {
+		t.Errorf("expected the rest of the output to be exported, got %d herpstat_output_alarm_high", n)
+	}
+}
+
+func TestCollectStaleData(t *testing.T) {
+	e := newFixtureExporter(t, "rawstatus_setpoints.json")
+	e.herpstat.device.MaxStaleness = time.Minute
+
+	if n, err := testutil.GatherAndCount(newTestRegistry(t, e), "herpstat_system_temperature_celsius"); err != nil || n != 1 {
+		t.Fatalf("expected fresh data to be exported, got %d metrics (%v)", n, err)
+	}
+
+	e.herpstat.Lock()
+	e.herpstat.lastPoll = time.Now().Add(-2 * time.Minute)
+	e.herpstat.Unlock()
+
+	families, err := newTestRegistry(t, e).Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+
+	// how old the data is and which device it came from are still useful, but none of the readings are
+	allowed := map[string]bool{
+		"herpstat_last_poll_timestamp_seconds": true,
+		"herpstat_poll_age_seconds":            true,
+		"herpstat_system_info":                 true,
+	}
+
+	for _, family := range families {
+		// the health metrics are the ones labelled by target rather than system
+		isHealth := false
+		for _, l := range family.GetMetric()[0].GetLabel() {
+			isHealth = isHealth || l.GetName() == "target"
+		}
+
+		if !isHealth && !allowed[family.GetName()] {
+			t.Errorf("expected stale data not to be exported, got %s", family.GetName())
+		}
+	}
+
+	if up := testutil.ToFloat64(e.herpstat.health.up); up != 0 {
+		t.Errorf("expected herpstat_up to be 0 for stale data, got %v", up)
+	}
+}
+
+func newTestRegistry(t *testing.T, e *Exporter) *prometheus.Registry {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(e); err != nil {
+		t.Fatalf("unable to register exporter: %s", err)
+	}
+
+	return registry
+}
+
+func TestCollectAlarms(t *testing.T) {
+	tests := []struct {
+		name     string
+		system   string
+		output   string
+		expected string
+	}{
+		{
+			"within the alarm",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Proportional Heating","probereadingTEMP":86,"enablehighlowalarm":1,"highalarm":95,"lowalarm":77}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 0
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 0
+herpstat_output_alarm_margin{output="1",system="Alarms"} 5
+`,
+		},
+		{
+			"above the high alarm",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Proportional Heating","probereadingTEMP":104,"enablehighlowalarm":1,"highalarm":95,"lowalarm":77}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 1
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 0
+herpstat_output_alarm_margin{output="1",system="Alarms"} -5
+`,
+		},
+		{
+			"below the low alarm",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Proportional Heating","probereadingTEMP":68,"enablehighlowalarm":1,"highalarm":95,"lowalarm":77}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 0
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 1
+herpstat_output_alarm_margin{output="1",system="Alarms"} -5
+`,
+		},
+		{
+			// margins are in Celsius whichever unit the device uses
+			"celsius",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":25}`,
+			`{"outputmode":"Proportional Heating","probereadingTEMP":30,"enablehighlowalarm":1,"highalarm":29,"lowalarm":23}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 1
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 0
+herpstat_output_alarm_margin{output="1",system="Alarms"} -1
+`,
+		},
+		{
+			"humidity",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Humidifying","probereadingRH":50,"enablehighlowalarm":1,"highalarm":80,"lowalarm":60}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 0
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 1
+herpstat_output_alarm_margin{output="1",system="Alarms"} -10
+`,
+		},
+		{
+			"alarm disabled",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Proportional Heating","probereadingTEMP":100,"enablehighlowalarm":0,"highalarm":95,"lowalarm":80}`,
+			`
+herpstat_output_alarm_active{direction="high",output="1",system="Alarms"} 0
+herpstat_output_alarm_active{direction="low",output="1",system="Alarms"} 0
+`,
+		},
+		{
+			// there's no probe reading to compare against the alarm
+			"no probe",
+			`{"nickname":"Alarms","numberofoutputs":1,"internaltemp":75}`,
+			`{"outputmode":"Timer","probereadingTEMP":100,"enablehighlowalarm":1,"highalarm":95,"lowalarm":80}`,
+			``,
+		},
+	}
+
+	for _, tt := range tests {
+		e := newExporter(newDeviceConfig(fixtureAddress))
+		e.herpstat.client.Transport = fixtureTransport(`{"system":` + tt.system + `,"output1":` + tt.output + `}`)
+
+		if !e.herpstat.refresh() {
+			t.Fatalf("%s: unable to poll", tt.name)
+		}
+
+		if err := testutil.CollectAndCompare(e, strings.NewReader(alarmMetrics(tt.expected)), "herpstat_output_alarm_active", "herpstat_output_alarm_margin"); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+	}
+}
+
+// alarmMetrics adds the HELP and TYPE lines to the expected alarm_active and alarm_margin samples
+func alarmMetrics(samples string) string {
+	var active, margin []string
+
+	for _, line := range strings.Split(strings.TrimSpace(samples), "\n") {
+		switch {
+		case strings.HasPrefix(line, "herpstat_output_alarm_active"):
+			active = append(active, line)
+		case strings.HasPrefix(line, "herpstat_output_alarm_margin"):
+			margin = append(margin, line)
+		}
+	}
+
+	expected := ""
+
+	if len(active) > 0 {
+		expected += "# HELP herpstat_output_alarm_active Is the probe reading past the output's own high or low alarm setting?\n" +
+			"# TYPE herpstat_output_alarm_active gauge\n" + strings.Join(active, "\n") + "\n"
+	}
+
+	if len(margin) > 0 {
+		expected += "# HELP herpstat_output_alarm_margin Distance from the probe reading to the nearest alarm setting. Celsius for temperature outputs and percent for humidity outputs. Negative when in alarm.\n" +
+			"# TYPE herpstat_output_alarm_margin gauge\n" + strings.Join(margin, "\n") + "\n"
+	}
+
+	return expected
+}
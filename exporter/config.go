@@ -0,0 +1,373 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultPollAttempts  = 3
+	defaultPollInterval  = 10 * time.Second
+	defaultPollRetryWait = 3 * time.Second
+	defaultPollTimeout   = 5 * time.Second
+
+	defaultWebhookRetries   = 3
+	defaultWebhookRetryWait = 5 * time.Second
+	defaultWebhookTimeout   = 5 * time.Second
+
+	alertFieldTemperature = "temperature"
+	alertFieldHumidity    = "humidity"
+)
+
+// config describes everything in the YAML file given via --config.file
+//
+//	devices:
+//	  - address: 1.2.3.4
+//	    name: ball-pythons
+//	    poll_interval: 10s
+//	    poll_attempts: 3
+//	    poll_retry_wait: 3s
+//	    timeout: 5s
+//	    max_staleness: 1m
+//	    temperature_unit: auto
+//	    username: admin
+//	    password: hunter2
+//	    labels:
+//	      room: reptile-room
+//	    validation:
+//	      hold_last_good: 3
+//	      fields:
+//	        temperature:
+//	          max_rate: 1
+//	    outputs:
+//	      "1":
+//	        wattage: 150
+//	alerts:
+//	  rules:
+//	    - name: basking-spot-too-hot
+//	      device: ball-pythons
+//	      output: "1"
+//	      field: temperature
+//	      above: 35
+//	      for: 2m
+//	      hysteresis: 1
+//	  webhooks:
+//	    - url: http://example.com/hook
+//	error_codes:
+//	  2:
+//	    reason: probe_shorted
+//	    severity: critical
+//	mqtt:
+//	  broker: tcp://localhost:1883
+type config struct {
+	Devices    []*deviceConfig   `yaml:"devices"`
+	Alerts     alertsConfig      `yaml:"alerts,omitempty"`
+	ErrorCodes map[int]errorCode `yaml:"error_codes,omitempty"`
+	MQTT       mqttConfig        `yaml:"mqtt,omitempty"`
+}
+
+// deviceConfig holds everything we need to know in order to poll a single Herpstat SpyderWeb
+type deviceConfig struct {
+	Address         string                   `yaml:"address"`
+	Name            string                   `yaml:"name,omitempty"`
+	Username        string                   `yaml:"username,omitempty"`
+	Password        string                   `yaml:"password,omitempty"`
+	PollInterval    time.Duration            `yaml:"poll_interval,omitempty"`
+	PollAttempts    int                      `yaml:"poll_attempts,omitempty"`
+	PollRetryWait   time.Duration            `yaml:"poll_retry_wait,omitempty"`
+	Timeout         time.Duration            `yaml:"timeout,omitempty"`
+	MaxStaleness    time.Duration            `yaml:"max_staleness,omitempty"`
+	TemperatureUnit temperatureUnit          `yaml:"temperature_unit,omitempty"`
+	Labels          map[string]string        `yaml:"labels,omitempty"`
+	Validation      validationConfig         `yaml:"validation,omitempty"`
+	Outputs         map[string]*outputConfig `yaml:"outputs,omitempty"`
+}
+
+// alertsConfig describes the optional, built-in alert rules and where to send their notifications
+type alertsConfig struct {
+	Rules    []*alertRule     `yaml:"rules,omitempty"`
+	Webhooks []*webhookConfig `yaml:"webhooks,omitempty"`
+}
+
+// alertRule is a single threshold check against an output's probe readings, in Celsius for temperatures and percent
+// for humidity, the same as the rest of the config file. Device and Output can be an address/ID or a name, and match
+// everything when left empty. DeviceAlarm uses the output's own high/low alarm whenever it's enabled.
+type alertRule struct {
+	Name        string        `yaml:"name"`
+	Device      string        `yaml:"device,omitempty"`
+	Output      string        `yaml:"output,omitempty"`
+	Field       string        `yaml:"field"`
+	Above       *float64      `yaml:"above,omitempty"`
+	Below       *float64      `yaml:"below,omitempty"`
+	DeviceAlarm bool          `yaml:"device_alarm,omitempty"`
+	For         time.Duration `yaml:"for,omitempty"`
+	Hysteresis  float64       `yaml:"hysteresis,omitempty"`
+}
+
+// webhookConfig is somewhere that alert notifications are POSTed to as JSON
+type webhookConfig struct {
+	URL       string        `yaml:"url"`
+	Retries   int           `yaml:"retries,omitempty"`
+	RetryWait time.Duration `yaml:"retry_wait,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+}
+
+// newDeviceConfig returns a [exporter.deviceConfig] for the given address with all of the defaults filled in. This
+// is used for --herpstat.address and for any /probe targets that aren't in the config file.
+func newDeviceConfig(address string) *deviceConfig {
+	d := &deviceConfig{Address: address}
+	d.setDefaults()
+
+	return d
+}
+
+// setDefaults fills in anything that wasn't set in the config file. MaxStaleness and TemperatureUnit fall back to
+// --herpstat.max-staleness and --herpstat.temperature-unit.
+func (d *deviceConfig) setDefaults() {
+	if d.PollInterval == 0 {
+		d.PollInterval = defaultPollInterval
+	}
+
+	if d.PollAttempts == 0 {
+		d.PollAttempts = defaultPollAttempts
+	}
+
+	if d.PollRetryWait == 0 {
+		d.PollRetryWait = defaultPollRetryWait
+	}
+
+	if d.Timeout == 0 {
+		d.Timeout = defaultPollTimeout
+	}
+
+	// the flag applies to every device, so it's raised for any device that would otherwise be stale for most of
+	// every poll interval. one that's set in the config file is checked by [exporter.config.validate] instead.
+	if d.MaxStaleness == 0 {
+		d.MaxStaleness = *herpstatMaxStaleness
+
+		if cycle := d.pollCycle(); d.MaxStaleness > 0 && d.MaxStaleness < cycle {
+			d.MaxStaleness = cycle
+		}
+	}
+
+	if d.TemperatureUnit == "" {
+		d.TemperatureUnit = temperatureUnit(*herpstatTemperatureUnit)
+	}
+
+	if d.TemperatureUnit == "" {
+		d.TemperatureUnit = unitAuto
+	}
+
+	if d.Labels == nil {
+		d.Labels = map[string]string{}
+	}
+}
+
+// pollCycle is the longest that a device can go between successful polls while everything is working, which is the
+// poll interval plus every attempt timing out and waiting before the next one
+func (d *deviceConfig) pollCycle() time.Duration {
+	return d.PollInterval + time.Duration(d.PollAttempts)*d.Timeout + time.Duration(d.PollAttempts-1)*d.PollRetryWait
+}
+
+// loadConfig reads the YAML config file at the given path. It still needs to be checked via
+// [exporter.config.validate] before use.
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	c := &config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse config file: %w", err)
+	}
+
+	return c, nil
+}
+
+// validate checks that every device has an address, usable poll settings and usable extra labels. Every device
+// needs to have the same set of label names in order to share metric names, so any labels that are missing from a
+// device are filled in with an empty value.
+func (c *config) validate() error {
+	seen := map[string]bool{}
+	names := map[string]bool{}
+	labelNames := map[string]bool{}
+
+	for i, d := range c.Devices {
+		if d.Address == "" {
+			return fmt.Errorf("device #%d is missing an address", i+1)
+		}
+
+		if seen[d.Address] {
+			return fmt.Errorf("device %s is listed more than once", d.Address)
+		}
+
+		seen[d.Address] = true
+
+		// the name is the "system" label, which is all that tells the devices' metrics apart
+		if d.Name != "" && names[d.Name] {
+			return fmt.Errorf("device %s has the same name as another device: %s", d.Address, d.Name)
+		}
+
+		names[d.Name] = true
+
+		for name := range d.Labels {
+			if !model.LabelName(name).IsValid() {
+				return fmt.Errorf("device %s has an invalid label name: %s", d.Address, name)
+			}
+
+			if isReservedLabelName(name) {
+				return fmt.Errorf("device %s has a label that's already used by the exporter: %s", d.Address, name)
+			}
+
+			labelNames[name] = true
+		}
+
+		// anything that's left at 0 comes from the flag, which setDefaults raises if it's too short
+		maxStaleness := d.MaxStaleness
+
+		d.setDefaults()
+
+		// anything that's left at 0 was replaced by its default above, so only negative values get this far
+		if d.PollInterval <= 0 {
+			return fmt.Errorf("device %s has a poll_interval that isn't positive: %s", d.Address, d.PollInterval)
+		}
+
+		if d.PollAttempts <= 0 {
+			return fmt.Errorf("device %s has a poll_attempts that isn't positive: %d", d.Address, d.PollAttempts)
+		}
+
+		if d.PollRetryWait < 0 {
+			return fmt.Errorf("device %s has a negative poll_retry_wait: %s", d.Address, d.PollRetryWait)
+		}
+
+		if d.Timeout < 0 {
+			return fmt.Errorf("device %s has a negative timeout: %s", d.Address, d.Timeout)
+		}
+
+		if cycle := d.pollCycle(); maxStaleness > 0 && maxStaleness < cycle {
+			return fmt.Errorf("device %s has a max_staleness of %s, which is shorter than its poll interval plus every poll attempt and retry wait (%s)", d.Address, maxStaleness, cycle)
+		}
+
+		unit, err := parseTemperatureUnit(string(d.TemperatureUnit))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", d.Address, err)
+		}
+
+		d.TemperatureUnit = unit
+
+		if err := d.Validation.validate(); err != nil {
+			return fmt.Errorf("device %s: %w", d.Address, err)
+		}
+
+		if err := validateOutputConfigs(d.Outputs); err != nil {
+			return fmt.Errorf("device %s: %w", d.Address, err)
+		}
+	}
+
+	for _, d := range c.Devices {
+		for name := range labelNames {
+			if _, ok := d.Labels[name]; !ok {
+				d.Labels[name] = ""
+			}
+		}
+	}
+
+	if err := validateErrorCodes(c.ErrorCodes); err != nil {
+		return err
+	}
+
+	if err := c.MQTT.validate(); err != nil {
+		return err
+	}
+
+	return c.Alerts.validate()
+}
+
+// validate checks that every alert rule is usable and fills in the webhook defaults
+func (a *alertsConfig) validate() error {
+	seen := map[string]bool{}
+
+	for i, r := range a.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("alert rule #%d is missing a name", i+1)
+		}
+
+		if seen[r.Name] {
+			return fmt.Errorf("alert rule %s is listed more than once", r.Name)
+		}
+
+		seen[r.Name] = true
+
+		if r.Field != alertFieldTemperature && r.Field != alertFieldHumidity {
+			return fmt.Errorf("alert rule %s has an unknown field (%q). it must be %q or %q", r.Name, r.Field, alertFieldTemperature, alertFieldHumidity)
+		}
+
+		if r.Above == nil && r.Below == nil && !r.DeviceAlarm {
+			return fmt.Errorf("alert rule %s needs at least one of above, below or device_alarm", r.Name)
+		}
+
+		if r.Hysteresis < 0 {
+			return fmt.Errorf("alert rule %s has a negative hysteresis", r.Name)
+		}
+	}
+
+	if len(a.Rules) > 0 && len(a.Webhooks) == 0 {
+		return fmt.Errorf("alert rules are configured, but there aren't any webhooks to send them to")
+	}
+
+	for i, w := range a.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("webhook #%d is missing a url", i+1)
+		}
+
+		if w.Retries == 0 {
+			w.Retries = defaultWebhookRetries
+		}
+
+		if w.RetryWait == 0 {
+			w.RetryWait = defaultWebhookRetryWait
+		}
+
+		if w.Timeout == 0 {
+			w.Timeout = defaultWebhookTimeout
+		}
+	}
+
+	return nil
+}
+
+// isReservedLabelName checks whether a label name is already used by one of our own metrics
+func isReservedLabelName(name string) bool {
+	for _, names := range [][]string{
+		healthLabelNames,
+		systemInfoLabelNames,
+		systemSafetyRelayLabelNames,
+		systemUnitLabelNames,
+		systemStateLabelNames,
+		outputInfoLabelNames,
+		outputErrorLabelNames,
+		outputAlarmLabelNames,
+		outputModeLabelNames,
+		outputSetpointLabelNames,
+		outputProbeFaultLabelNames,
+		outputStateLabelNames,
+		outputResultLabelNames,
+		outputWindowLabelNames,
+		outputPhaseLabelNames,
+		outputPhaseWindowLabelNames,
+		outputErrorActiveLabelNames,
+	} {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
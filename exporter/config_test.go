@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// parseConfig parses and validates a config file the same way that [exporter.Run] does
+func parseConfig(t *testing.T, body string) (*config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("unable to write config file: %s", err)
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, c.validate()
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{"good", "devices:\n  - address: 1.2.3.4\n  - address: 5.6.7.8\n", true},
+		{"no devices", "devices: []\n", true},
+		{"missing address", "devices:\n  - name: ball-pythons\n", false},
+		{"duplicate name", "devices:\n  - address: 1.2.3.4\n    name: geckos\n  - address: 5.6.7.8\n    name: geckos\n", false},
+		{"duplicate address", "devices:\n  - address: 1.2.3.4\n  - address: 1.2.3.4\n    name: again\n", false},
+		{"good temperature unit", "devices:\n  - address: 1.2.3.4\n    temperature_unit: celsius\n", true},
+		{"bad temperature unit", "devices:\n  - address: 1.2.3.4\n    temperature_unit: kelvin\n", false},
+		{"invalid label name", "devices:\n  - address: 1.2.3.4\n    labels:\n      bad-label: x\n", false},
+		{"reserved label name", "devices:\n  - address: 1.2.3.4\n    labels:\n      system: x\n", false},
+		{"negative poll interval", "devices:\n  - address: 1.2.3.4\n    poll_interval: -1s\n", false},
+		{"negative poll attempts", "devices:\n  - address: 1.2.3.4\n    poll_attempts: -1\n", false},
+		{"negative poll retry wait", "devices:\n  - address: 1.2.3.4\n    poll_retry_wait: -1s\n", false},
+		{"negative timeout", "devices:\n  - address: 1.2.3.4\n    timeout: -1s\n", false},
+		// these are the same as leaving them out, so the defaults are used
+		{"zero poll settings", "devices:\n  - address: 1.2.3.4\n    poll_interval: 0s\n    poll_attempts: 0\n    poll_retry_wait: 0s\n    timeout: 0s\n", true},
+		{"max staleness shorter than a poll", "devices:\n  - address: 1.2.3.4\n    poll_interval: 2m\n    max_staleness: 1m\n", false},
+		{"max staleness longer than a poll", "devices:\n  - address: 1.2.3.4\n    poll_interval: 2m\n    max_staleness: 5m\n", true},
+		{"max staleness from the flag shorter than a poll", "devices:\n  - address: 1.2.3.4\n    poll_interval: 2m\n", true},
+		{"negative wattage", "devices:\n  - address: 1.2.3.4\n    outputs:\n      \"1\":\n        wattage: -1\n", false},
+		// typos are caught by UnmarshalStrict instead of being silently ignored
+		{"unknown top level key", "device:\n  - address: 1.2.3.4\n", false},
+		{"unknown device key", "devices:\n  - address: 1.2.3.4\n    poll_intervl: 5s\n", false},
+		{"unknown output key", "devices:\n  - address: 1.2.3.4\n    outputs:\n      \"1\":\n        watts: 150\n", false},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseConfig(t, tt.body); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestConfigUnknownKeysAreNamed(t *testing.T) {
+	_, err := parseConfig(t, "devices:\n  - address: 1.2.3.4\n    poll_intervl: 5s\n")
+
+	if err == nil || !strings.Contains(err.Error(), "poll_intervl") {
+		t.Errorf("expected the unknown key to be named in the error, got %v", err)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	maxStaleness, unit := *herpstatMaxStaleness, *herpstatTemperatureUnit
+	t.Cleanup(func() {
+		*herpstatMaxStaleness, *herpstatTemperatureUnit = maxStaleness, unit
+	})
+
+	// as if they were given on the command line
+	*herpstatMaxStaleness = 2 * time.Minute
+	*herpstatTemperatureUnit = string(unitFahrenheit)
+
+	c := &config{}
+	body := `
+devices:
+  - address: 1.2.3.4
+  - address: 5.6.7.8
+    poll_interval: 30s
+    max_staleness: 5m
+    temperature_unit: celsius
+    labels:
+      room: fish-room
+  - address: 9.10.11.12
+    poll_interval: 2m
+`
+	if err := yaml.UnmarshalStrict([]byte(body), c); err != nil {
+		t.Fatalf("unable to parse config: %s", err)
+	}
+
+	if err := c.validate(); err != nil {
+		t.Fatalf("expected the config to be valid, got %s", err)
+	}
+
+	defaults, overridden := c.Devices[0], c.Devices[1]
+
+	// anything that isn't in the config file comes from the built-in defaults or the flags
+	if defaults.PollInterval != defaultPollInterval || defaults.PollAttempts != defaultPollAttempts || defaults.Timeout != defaultPollTimeout {
+		t.Errorf("expected the built-in defaults, got %+v", defaults)
+	}
+
+	if defaults.MaxStaleness != 2*time.Minute || defaults.TemperatureUnit != unitFahrenheit {
+		t.Errorf("expected the flags to be used, got %v and %s", defaults.MaxStaleness, defaults.TemperatureUnit)
+	}
+
+	// while anything that is takes precedence over both
+	if overridden.PollInterval != 30*time.Second || overridden.PollAttempts != defaultPollAttempts {
+		t.Errorf("expected the device's own poll interval with the default attempts, got %+v", overridden)
+	}
+
+	if overridden.MaxStaleness != 5*time.Minute || overridden.TemperatureUnit != unitCelsius {
+		t.Errorf("expected the device's own settings to override the flags, got %v and %s", overridden.MaxStaleness, overridden.TemperatureUnit)
+	}
+
+	// every device ends up with the same label names
+	if value, ok := defaults.Labels["room"]; !ok || value != "" {
+		t.Errorf("expected an empty room label to be filled in, got %v", defaults.Labels)
+	}
+
+	if overridden.Labels["room"] != "fish-room" {
+		t.Errorf("expected the room label to be kept, got %v", overridden.Labels)
+	}
+
+	// the flag is raised for a device that would otherwise be stale for most of every poll interval
+	if slow := c.Devices[2]; slow.MaxStaleness != 2*time.Minute+defaultPollAttempts*defaultPollTimeout+(defaultPollAttempts-1)*defaultPollRetryWait {
+		t.Errorf("expected the max staleness to be raised to a whole poll cycle, got %v", slow.MaxStaleness)
+	}
+}
+
+func TestConfigDevicesShareARegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		systems []string
+	}{
+		{
+			name:    "named",
+			body:    "devices:\n  - address: 1.2.3.4\n    name: ball-pythons\n  - address: 5.6.7.8\n    name: geckos\n",
+			systems: []string{"ball-pythons", "geckos"},
+		},
+		{
+			// both devices report the same nickname, so the second one goes by its address instead
+			name:    "unnamed",
+			body:    "devices:\n  - address: 1.2.3.4\n  - address: 5.6.7.8\n",
+			systems: []string{"Reptile Room", "5.6.7.8"},
+		},
+		{
+			// a configured name wins, even over a device that was polled first
+			name:    "nickname matches a configured name",
+			body:    "devices:\n  - address: 1.2.3.4\n  - address: 5.6.7.8\n    name: Reptile Room\n",
+			systems: []string{"1.2.3.4", "Reptile Room"},
+		},
+	}
+
+	body := readFixture(t, "rawstatus_setpoints.json")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig(t, tt.body)
+			if err != nil {
+				t.Fatalf("expected the config to be valid, got %s", err)
+			}
+
+			// the same way that [exporter.Run] registers them for /metrics
+			registry := prometheus.NewRegistry()
+			names := newSystemNames()
+
+			for _, device := range c.Devices {
+				if device.Name != "" {
+					names.claim(device.Address, device.Name)
+				}
+			}
+
+			for _, device := range c.Devices {
+				e := newExporter(device)
+				e.herpstat.names = names
+				e.herpstat.client.Transport = fixtureTransport(body)
+
+				if !e.herpstat.refresh() {
+					t.Fatalf("unable to poll %s", device.Address)
+				}
+
+				if err := registry.Register(e); err != nil {
+					t.Fatalf("unable to register %s: %s", device.Address, err)
+				}
+			}
+
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("unable to gather metrics: %s", err)
+			}
+
+			var systems []string
+
+			for _, family := range families {
+				if family.GetName() != "herpstat_system_info" {
+					continue
+				}
+
+				for _, m := range family.GetMetric() {
+					for _, label := range m.GetLabel() {
+						if label.GetName() == "system" {
+							systems = append(systems, label.GetValue())
+						}
+					}
+				}
+			}
+
+			sort.Strings(systems)
+			sort.Strings(tt.systems)
+
+			if strings.Join(systems, ",") != strings.Join(tt.systems, ",") {
+				t.Errorf("expected systems %v, got %v", tt.systems, systems)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// dashboardStaticPath is where the dashboard's stylesheet, etc, are served from
+	dashboardStaticPath = "/static/"
+
+	// sparklines cover the last day of history, split into this many points
+	sparklineWindow = 24 * time.Hour
+	sparklinePoints = 144
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+// dashboardAssets holds the dashboard's template and static files, so that the binary is all that's needed to run it
+//
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(dashboardAssets, "dashboard/index.html"))
+
+// dashboard is a small HTML status page that shows the latest poll from every configured device, for people who
+// don't have Grafana. If there's a [exporter.historyStore], each output also gets a sparkline of its last day.
+type dashboard struct {
+	exporters []*Exporter
+	history   *historyStore
+}
+
+// dashboardPage is everything that the dashboard template is rendered with
+type dashboardPage struct {
+	Devices       []dashboardDevice
+	TelemetryPath string
+	Generated     time.Time
+	HasHistory    bool
+}
+
+type dashboardDevice struct {
+	Name         string
+	Address      string
+	Up           bool
+	Stale        bool
+	Polled       bool
+	LastPoll     time.Time
+	Age          string
+	SafetyRelay  string
+	Tripped      bool
+	InternalTemp string
+	Outputs      []dashboardOutput
+}
+
+type dashboardOutput struct {
+	ID         string
+	Name       string
+	Mode       string
+	Reading    string
+	Setpoint   string
+	Phase      string
+	Power      string
+	Alarm      string
+	Fault      string
+	Error      string
+	Severity   string
+	Sparkline  string
+	SparkRange string
+}
+
+func newDashboard(exporters []*Exporter, history *historyStore) *dashboard {
+	return &dashboard{exporters: exporters, history: history}
+}
+
+// handler serves the dashboard at / and its static files under [exporter.dashboardStaticPath]. Anything else is a 404,
+// since / matches every path that nothing else has been registered for.
+func (d *dashboard) handler() http.Handler {
+	static, err := fs.Sub(dashboardAssets, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.StripPrefix(dashboardStaticPath, http.FileServer(http.FS(static)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			d.render(w)
+		case strings.HasPrefix(r.URL.Path, dashboardStaticPath) && r.URL.Path != dashboardStaticPath+"index.html":
+			files.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (d *dashboard) render(w http.ResponseWriter) {
+	page := &dashboardPage{TelemetryPath: *webTelemetryPath, Generated: time.Now(), HasHistory: d.history != nil}
+
+	for _, e := range d.exporters {
+		page.Devices = append(page.Devices, d.device(e.herpstat, page.Generated))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		level.Error(logger).Log("msg", "unable to render dashboard", "err", err)
+	}
+}
+
+// device summarizes a device's most recent poll
+func (d *dashboard) device(h *herpstat, now time.Time) dashboardDevice {
+	info, lastPoll, up := h.snapshot()
+
+	device := dashboardDevice{
+		Name:     h.device.Name,
+		Address:  h.device.Address,
+		Up:       up,
+		Polled:   !lastPoll.IsZero(),
+		LastPoll: lastPoll,
+	}
+
+	if !device.Polled {
+		return device
+	}
+
+	device.Stale = h.isStale(lastPoll)
+	device.Age = now.Sub(lastPoll).Truncate(time.Second).String()
+
+	if device.Name == "" {
+		device.Name = info.system.Name
+	}
+
+	relay := parseSafetyRelay(info.system.SafetyRelay)
+	device.SafetyRelay = string(relay)
+	device.Tripped = relay.isTripped()
+
+	if info.system.invalid.valid(fieldInternalTemperature) {
+		device.InternalTemp = formatCelsius(info.system.unit.toCelsius(info.system.Temp))
+	}
+
+	for i := range *info.outputs {
+		device.Outputs = append(device.Outputs, d.output(info.system.Name, &(*info.outputs)[i], now))
+	}
+
+	return device
+}
+
+// output summarizes a single output, with temperatures and settings in Celsius just like the metrics
+func (d *dashboard) output(systemName string, o *output, now time.Time) dashboardOutput {
+	out := dashboardOutput{
+		ID:    o.ID,
+		Name:  o.Name,
+		Mode:  o.Mode,
+		Power: fmt.Sprintf("%g%% of %g%%", o.Power, o.PowerLimit),
+	}
+
+	if !o.invalid.valid(fieldPower) {
+		out.Power = "invalid"
+	}
+
+	entry := errorCodes.lookup(o.ErrorCode, o.ErrorDesc)
+	out.Severity = entry.Severity
+
+	if entry.Severity != severityNone {
+		out.Error = fmt.Sprintf("%s (%s)", o.ErrorDesc, entry.Reason)
+	}
+
+	if !o.mode.hasProbe() {
+		return out
+	}
+
+	out.Fault = o.probeStatus()
+
+	format := formatCelsius
+	field := fieldTemperature
+
+	if o.measuresHumidity() {
+		format = formatPercent
+		field = fieldHumidity
+	}
+
+	if reading, ok := o.probeReading(); ok {
+		out.Reading = format(o.setting(reading))
+	}
+
+	if o.Setpoint != nil && o.hasKnownUnit() {
+		out.Setpoint = format(o.setting(*o.Setpoint))
+	}
+
+	if phase, ok := o.schedulePhase(); ok {
+		out.Phase = string(phase)
+	}
+
+	if o.AlarmEnabled == 1 && o.hasKnownUnit() {
+		out.Alarm = fmt.Sprintf("%s – %s", format(o.setting(o.AlarmLow)), format(o.setting(o.AlarmHigh)))
+	}
+
+	if d.history != nil {
+		out.Sparkline, out.SparkRange = d.sparkline(systemName, o.ID, field, now, format)
+	}
+
+	return out
+}
+
+// sparkline turns the last day of an output's history into the points of an SVG polyline, along with a description
+// of its range
+func (d *dashboard) sparkline(systemName, outputID, field string, now time.Time, format func(float64) string) (string, string) {
+	points, err := d.history.query(systemName, outputID, field, now.Add(-sparklineWindow), now, sparklineWindow/sparklinePoints)
+	if err != nil {
+		level.Error(logger).Log("msg", "unable to read history for the dashboard", "err", err)
+		return "", ""
+	}
+
+	if len(points) < 2 {
+		return "", ""
+	}
+
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		low, high = math.Min(low, p.Value), math.Max(high, p.Value)
+	}
+
+	span := high - low
+	if span == 0 {
+		span = 1
+	}
+
+	coords := make([]string, 0, len(points))
+
+	for _, p := range points {
+		x := float64(sparklineWidth) * p.Timestamp.Sub(now.Add(-sparklineWindow)).Seconds() / sparklineWindow.Seconds()
+		y := float64(sparklineHeight) - float64(sparklineHeight)*(p.Value-low)/span
+
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+
+	return strings.Join(coords, " "), fmt.Sprintf("%s to %s over the last day", format(low), format(high))
+}
+
+func formatCelsius(value float64) string {
+	return fmt.Sprintf("%.1f°C", value)
+}
+
+func formatPercent(value float64) string {
+	return fmt.Sprintf("%.1f%%", value)
+}
@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+)
+
+func TestDashboard(t *testing.T) {
+	device := simulator.New(simulator.Config{Nickname: "Dashboard", Outputs: 2})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	device.UnplugProbe(2)
+
+	e := newExporter(newDeviceConfig(strings.TrimPrefix(server.URL, "http://")))
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll the simulator")
+	}
+
+	history, err := newHistoryStore(t.TempDir(), 2*time.Hour, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, _, _ := e.herpstat.snapshot()
+	now := time.Now()
+
+	history.polled(e.herpstat.device, info, now.Add(-time.Hour))
+	history.polled(e.herpstat.device, info, now)
+
+	handler := newDashboard([]*Exporter{e, newExporter(newDeviceConfig("never.polled"))}, history).handler()
+
+	tests := []struct {
+		path     string
+		status   int
+		contains []string
+	}{
+		{"/", http.StatusOK, []string{"Dashboard", "Output 1", "Output 2", "probe disconnected", "<polyline", "never.polled", "not polled yet"}},
+		{"/static/style.css", http.StatusOK, []string{".sparkline"}},
+		{"/static/index.html", http.StatusNotFound, nil},
+		{"/nothing-here", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, http.NoBody))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.status, rec.Code)
+			continue
+		}
+
+		for _, s := range tt.contains {
+			if !strings.Contains(rec.Body.String(), s) {
+				t.Errorf("%s: expected the page to contain %q", tt.path, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// devicesPath lists every configured device, and devicesPath/{name} returns a single one
+	devicesPath = "/api/v1/devices"
+
+	// deviceSchemaVersion is bumped whenever a field is removed or changes meaning. New fields can be added without
+	// bumping it, so integrations should ignore anything they don't recognize.
+	deviceSchemaVersion = 1
+
+	unitPercent = "percent"
+)
+
+// devicesResponse is returned by [exporter.devicesPath]
+type devicesResponse struct {
+	SchemaVersion int            `json:"schema_version"`
+	Devices       []deviceStatus `json:"devices"`
+}
+
+// deviceResponse is returned by [exporter.devicesPath]/{name}
+type deviceResponse struct {
+	SchemaVersion int          `json:"schema_version"`
+	Device        deviceStatus `json:"device"`
+}
+
+// deviceStatus is the parsed and validated model of a single device. System and outputs are left out until the
+// device has been polled successfully. Temperatures are always in Celsius.
+type deviceStatus struct {
+	Name    string         `json:"name"`
+	Address string         `json:"address"`
+	Health  deviceHealth   `json:"health"`
+	System  *systemStatus  `json:"system,omitempty"`
+	Outputs []outputStatus `json:"outputs"`
+}
+
+type deviceHealth struct {
+	Up                  bool       `json:"up"`
+	Stale               bool       `json:"stale"`
+	LastPoll            *time.Time `json:"last_poll,omitempty"`
+	CacheAgeSeconds     *float64   `json:"cache_age_seconds,omitempty"`
+	PollIntervalSeconds float64    `json:"poll_interval_seconds"`
+	MaxStalenessSeconds float64    `json:"max_staleness_seconds"`
+	LastReset           *time.Time `json:"last_reset,omitempty"`
+}
+
+type systemStatus struct {
+	Nickname                   string           `json:"nickname"`
+	IP                         string           `json:"ip"`
+	MAC                        string           `json:"mac"`
+	Firmware                   string           `json:"firmware"`
+	OutputCount                int              `json:"output_count"`
+	PowerResets                float64          `json:"power_resets"`
+	ReportedUnit               temperatureUnit  `json:"reported_unit"`
+	InternalTemperatureCelsius *float64         `json:"internal_temperature_celsius"`
+	SafetyRelay                safetyRelayState `json:"safety_relay"`
+	SafetyRelayMessage         string           `json:"safety_relay_message"`
+}
+
+type outputStatus struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	Mode          outputMode         `json:"mode"`
+	ModeMessage   string             `json:"mode_message"`
+	Measures      string             `json:"measures,omitempty"`
+	Unit          string             `json:"unit,omitempty"`
+	Reading       *float64           `json:"reading"`
+	Setpoints     map[string]float64 `json:"setpoints,omitempty"`
+	SchedulePhase schedulePhase      `json:"schedule_phase,omitempty"`
+	PowerPercent  *float64           `json:"power_percent"`
+	PowerLimit    float64            `json:"power_limit_percent"`
+	Alarm         *alarmStatus       `json:"alarm,omitempty"`
+	Ramp          *rampStatus        `json:"ramp,omitempty"`
+	ProbeFault    string             `json:"probe_fault,omitempty"`
+	Error         errorStatus        `json:"error"`
+}
+
+type alarmStatus struct {
+	Enabled bool    `json:"enabled"`
+	Low     float64 `json:"low"`
+	High    float64 `json:"high"`
+	Active  string  `json:"active,omitempty"`
+}
+
+type rampStatus struct {
+	State rampState `json:"state"`
+	End   *float64  `json:"end,omitempty"`
+}
+
+type errorStatus struct {
+	Code        float64 `json:"code"`
+	Reason      string  `json:"reason"`
+	Severity    string  `json:"severity"`
+	Description string  `json:"description"`
+}
+
+// devicesHandler serves [exporter.devicesPath] and [exporter.devicesPath]/{name}, where name is a device's
+// configured name, its address or the nickname that it gave itself
+func devicesHandler(exporters []*Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, devicesPath), "/")
+
+		if name == "" {
+			response := devicesResponse{SchemaVersion: deviceSchemaVersion, Devices: []deviceStatus{}}
+
+			for _, e := range exporters {
+				response.Devices = append(response.Devices, e.herpstat.status(now))
+			}
+
+			writeJSON(w, http.StatusOK, response)
+
+			return
+		}
+
+		for _, e := range exporters {
+			status := e.herpstat.status(now)
+
+			if name == status.Name || name == status.Address || (status.System != nil && name == status.System.Nickname) {
+				writeJSON(w, http.StatusOK, deviceResponse{SchemaVersion: deviceSchemaVersion, Device: status})
+				return
+			}
+		}
+
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("no device named %q", name))
+	}
+}
+
+// status returns the device's most recent poll as a [exporter.deviceStatus]
+func (h *herpstat) status(now time.Time) deviceStatus {
+	info, lastPoll, up := h.snapshot()
+
+	status := deviceStatus{
+		Name:    h.device.Name,
+		Address: h.device.Address,
+		Health: deviceHealth{
+			Up:                  up,
+			PollIntervalSeconds: h.device.PollInterval.Seconds(),
+			MaxStalenessSeconds: h.device.MaxStaleness.Seconds(),
+		},
+		Outputs: []outputStatus{},
+	}
+
+	if reset := h.lastResetTime(); !reset.IsZero() {
+		status.Health.LastReset = &reset
+	}
+
+	if lastPoll.IsZero() {
+		return status
+	}
+
+	age := now.Sub(lastPoll).Seconds()
+
+	status.Health.LastPoll = &lastPoll
+	status.Health.CacheAgeSeconds = &age
+	status.Health.Stale = h.isStale(lastPoll)
+
+	if status.Name == "" {
+		status.Name = info.system.Name
+	}
+
+	status.System = info.system.status()
+
+	for i := range *info.outputs {
+		status.Outputs = append(status.Outputs, (*info.outputs)[i].status())
+	}
+
+	return status
+}
+
+func (s *system) status() *systemStatus {
+	status := &systemStatus{
+		Nickname:           s.Name,
+		IP:                 s.IP,
+		MAC:                s.Mac,
+		Firmware:           s.firmware(),
+		OutputCount:        int(s.OutputCount),
+		PowerResets:        s.PowerResets,
+		ReportedUnit:       s.unit,
+		SafetyRelay:        parseSafetyRelay(s.SafetyRelay),
+		SafetyRelayMessage: s.SafetyRelay,
+	}
+
+	if s.invalid.valid(fieldInternalTemperature) {
+		temp := s.unit.toCelsius(s.Temp)
+		status.InternalTemperatureCelsius = &temp
+	}
+
+	return status
+}
+
+// status returns an output as an [exporter.outputStatus], leaving out anything that doesn't make sense for its mode
+// or can't be converted to Celsius
+func (o *output) status() outputStatus {
+	entry := errorCodes.lookup(o.ErrorCode, o.ErrorDesc)
+
+	status := outputStatus{
+		ID:          o.ID,
+		Name:        o.Name,
+		Mode:        o.mode,
+		ModeMessage: o.Mode,
+		PowerLimit:  o.PowerLimit,
+		Error: errorStatus{
+			Code:        o.ErrorCode,
+			Reason:      entry.Reason,
+			Severity:    entry.Severity,
+			Description: o.ErrorDesc,
+		},
+	}
+
+	if o.invalid.valid(fieldPower) {
+		power := o.Power
+		status.PowerPercent = &power
+	}
+
+	if !o.mode.hasProbe() {
+		return status
+	}
+
+	status.Measures, status.Unit = fieldTemperature, string(unitCelsius)
+	if o.measuresHumidity() {
+		status.Measures, status.Unit = fieldHumidity, unitPercent
+	}
+
+	status.ProbeFault = o.probeStatus()
+
+	reading, ok := o.probeReading()
+	if ok {
+		converted := o.setting(reading)
+		status.Reading = &converted
+	}
+
+	// settings can't be converted to Celsius until the device's unit is known
+	if !o.hasKnownUnit() {
+		return status
+	}
+
+	status.Setpoints = map[string]float64{}
+
+	for setting, value := range o.setpoints() {
+		if value != nil {
+			status.Setpoints[setting] = o.setting(*value)
+		}
+	}
+
+	if phase, ok := o.schedulePhase(); ok {
+		status.SchedulePhase = phase
+	}
+
+	status.Alarm = &alarmStatus{
+		Enabled: o.AlarmEnabled == 1,
+		Low:     o.setting(o.AlarmLow),
+		High:    o.setting(o.AlarmHigh),
+	}
+
+	if ok {
+		switch high, low := o.alarmActive(reading); {
+		case high == 1:
+			status.Alarm.Active = alarmDirectionHigh
+		case low == 1:
+			status.Alarm.Active = alarmDirectionLow
+		}
+	}
+
+	status.Ramp = &rampStatus{State: parseRampState(o.Ramping)}
+
+	// a missing endoframpsetting decodes to 0, which would otherwise be reported as -17.8°C
+	if o.RampEnd != 0 {
+		end := o.setting(o.RampEnd)
+		status.Ramp.End = &end
+	}
+
+	return status
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+)
+
+func TestDevicesAPI(t *testing.T) {
+	device := simulator.New(simulator.Config{Nickname: "Simulated", Outputs: 2})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	device.UnplugProbe(2)
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	polled := newExporter(newDeviceConfig(address))
+
+	if !polled.herpstat.refresh() {
+		t.Fatal("unable to poll the simulator")
+	}
+
+	configured := newDeviceConfig("never.polled")
+	configured.Name = "spare-room"
+
+	handler := devicesHandler([]*Exporter{polled, newExporter(configured)})
+
+	get := func(path string, body interface{}) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+
+		if err := json.NewDecoder(rec.Body).Decode(body); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		return rec.Code
+	}
+
+	var list devicesResponse
+	if code := get(devicesPath, &list); code != http.StatusOK || list.SchemaVersion != deviceSchemaVersion || len(list.Devices) != 2 {
+		t.Fatalf("expected both devices, got %d: %+v", code, list)
+	}
+
+	if d := list.Devices[1]; d.Name != "spare-room" || d.System != nil || d.Health.LastPoll != nil || len(d.Outputs) != 0 {
+		t.Errorf("expected a device that hasn't been polled to only have its health, got %+v", d)
+	}
+
+	for _, name := range []string{"Simulated", address} {
+		var single deviceResponse
+		if code := get(devicesPath+"/"+name, &single); code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", name, code)
+		}
+
+		d := single.Device
+		if d.Name != "Simulated" || !d.Health.Up || d.Health.CacheAgeSeconds == nil || d.System == nil || d.System.SafetyRelay != relayOff {
+			t.Errorf("%s: unexpected device %+v", name, d)
+		}
+
+		if len(d.Outputs) != 2 {
+			t.Fatalf("%s: expected 2 outputs, got %+v", name, d.Outputs)
+		}
+
+		if o := d.Outputs[0]; o.Mode != modeHeating || o.Unit != string(unitCelsius) || o.Reading == nil || o.ProbeFault != "" || o.Error.Reason != "none" {
+			t.Errorf("%s: unexpected output 1 %+v", name, o)
+		}
+
+		if o := d.Outputs[1]; o.ProbeFault != probeFaultDisconnected || o.Error.Reason != errorCodeUnknown || o.Error.Description != "Probe Disconnected" {
+			t.Errorf("%s: expected output 2's probe to be disconnected, got %+v", name, o)
+		}
+	}
+
+	var missing map[string]string
+	if code := get(devicesPath+"/nope", &missing); code != http.StatusNotFound || missing["error"] == "" {
+		t.Errorf("expected a 404 for an unknown device, got %d: %v", code, missing)
+	}
+}
+
+func TestDevicesAPIRampEnd(t *testing.T) {
+	e := newFixtureExporter(t, "rawstatus_setpoints.json")
+	status := e.herpstat.status(time.Now())
+
+	tests := []struct {
+		output int
+		end    *float64
+	}{
+		{1, float64Ptr(35)},
+		// a missing endoframpsetting isn't reported as -17.8°C, the same as herpstat_output_ramp_end
+		{2, nil},
+	}
+
+	for _, tt := range tests {
+		ramp := status.Outputs[tt.output-1].Ramp
+		if ramp == nil {
+			t.Fatalf("output %d: expected a ramp", tt.output)
+		}
+
+		switch {
+		case tt.end == nil && ramp.End != nil:
+			t.Errorf("output %d: expected no ramp end, got %v", tt.output, *ramp.End)
+		case tt.end != nil && (ramp.End == nil || *ramp.End != *tt.end):
+			t.Errorf("output %d: expected a ramp end of %v, got %v", tt.output, *tt.end, ramp.End)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-kit/log/level"
+)
+
+// severities that an error code can have, used as the "severity" label on herpstat_output_error_active
+const (
+	severityNone     = "none"
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+
+	// errorCodeUnknown is the "code" and "reason" label for every error code that isn't in the catalog
+	errorCodeUnknown = "unknown"
+)
+
+var (
+	severities = []string{severityNone, severityInfo, severityWarning, severityCritical}
+
+	// defaultErrorCodes are the error codes whose meaning we actually know. 0 is what an output reports alongside
+	// "No Error" (and what a missing errorcode decodes to). There's no published list of the others, so they're
+	// exported as unknown until they're added via the config file's error_codes.
+	defaultErrorCodes = map[int]errorCode{
+		0: {Reason: "none", Severity: severityNone},
+	}
+
+	// errorCodes is the catalog used by every [exporter.Exporter]. It's replaced by [exporter.Run] once the config
+	// file has been loaded.
+	errorCodes = newErrorCatalog(nil)
+)
+
+// errorCode is a stable, short description of one of the device's numeric error codes
+type errorCode struct {
+	Reason   string `yaml:"reason"`
+	Severity string `yaml:"severity"`
+}
+
+// errorCatalogEntry is an [exporter.errorCode] along with its code, as it's used in labels
+type errorCatalogEntry struct {
+	code string
+	errorCode
+}
+
+// errorCatalog maps the numeric error codes that outputs report to an [exporter.errorCode]. Codes that aren't in the
+// catalog all share a single "unknown" entry, and each one is logged the first time that it shows up.
+type errorCatalog struct {
+	sync.Mutex
+
+	entries []errorCatalogEntry
+	codes   map[int]errorCatalogEntry
+	logged  map[float64]bool
+}
+
+// newErrorCatalog creates a catalog of [exporter.defaultErrorCodes], overridden by any extra codes from the config
+// file.
+func newErrorCatalog(extra map[int]errorCode) *errorCatalog {
+	merged := map[int]errorCode{}
+
+	for code, e := range defaultErrorCodes {
+		merged[code] = e
+	}
+
+	for code, e := range extra {
+		merged[code] = e
+	}
+
+	numbers := make([]int, 0, len(merged))
+	for code := range merged {
+		numbers = append(numbers, code)
+	}
+
+	sort.Ints(numbers)
+
+	c := &errorCatalog{
+		codes:  map[int]errorCatalogEntry{},
+		logged: map[float64]bool{},
+	}
+
+	for _, code := range numbers {
+		entry := errorCatalogEntry{code: strconv.Itoa(code), errorCode: merged[code]}
+
+		c.codes[code] = entry
+		c.entries = append(c.entries, entry)
+	}
+
+	c.entries = append(c.entries, errorCatalogEntry{
+		code:      errorCodeUnknown,
+		errorCode: errorCode{Reason: errorCodeUnknown, Severity: severityWarning},
+	})
+
+	return c
+}
+
+// lookup finds the catalog entry for an output's error code, falling back to the unknown entry
+func (c *errorCatalog) lookup(code float64, desc string) errorCatalogEntry {
+	if entry, ok := c.codes[int(code)]; ok && float64(int(code)) == code {
+		return entry
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.logged[code] {
+		c.logged[code] = true
+
+		level.Warn(logger).Log("msg", "Unknown output error code. Add it to error_codes in the config file to give it a reason and severity.", "code", code, "description", desc)
+	}
+
+	return c.entries[len(c.entries)-1]
+}
+
+// validateErrorCodes checks that every error code from the config file has a usable reason and severity
+func validateErrorCodes(codes map[int]errorCode) error {
+	for code, e := range codes {
+		if e.Reason == "" || e.Reason == errorCodeUnknown {
+			return fmt.Errorf("error code %d needs a reason other than %q", code, errorCodeUnknown)
+		}
+
+		if !isSeverity(e.Severity) {
+			return fmt.Errorf("error code %d has an unknown severity (%q). it must be one of %v", code, e.Severity, severities)
+		}
+	}
+
+	return nil
+}
+
+func isSeverity(severity string) bool {
+	for _, s := range severities {
+		if s == severity {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *output) errorActiveLabelValues(systemName *string, entry errorCatalogEntry) []string {
+	return []string{*systemName, o.ID, entry.code, entry.Reason, entry.Severity}
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestErrorCatalogLookup(t *testing.T) {
+	c := &config{}
+	if err := yaml.UnmarshalStrict([]byte("error_codes:\n  7:\n    reason: probe_shorted\n    severity: critical\n"), c); err != nil {
+		t.Fatalf("unable to parse error_codes: %s", err)
+	}
+
+	catalog := newErrorCatalog(c.ErrorCodes)
+
+	tests := []struct {
+		code     float64
+		want     string
+		reason   string
+		severity string
+	}{
+		{0, "0", "none", severityNone},
+		{1, errorCodeUnknown, errorCodeUnknown, severityWarning},
+		{7, "7", "probe_shorted", severityCritical},
+		{42, errorCodeUnknown, errorCodeUnknown, severityWarning},
+		{42, errorCodeUnknown, errorCodeUnknown, severityWarning},
+		{1.5, errorCodeUnknown, errorCodeUnknown, severityWarning},
+	}
+
+	for _, tt := range tests {
+		entry := catalog.lookup(tt.code, "")
+		if entry.code != tt.want || entry.Reason != tt.reason || entry.Severity != tt.severity {
+			t.Errorf("%v: expected %s/%s/%s, got %s/%s/%s", tt.code, tt.want, tt.reason, tt.severity, entry.code, entry.Reason, entry.Severity)
+		}
+	}
+
+	if len(catalog.logged) != 3 {
+		t.Errorf("expected each unknown code to be logged once, got %v", catalog.logged)
+	}
+
+	if last := catalog.entries[len(catalog.entries)-1]; last.code != errorCodeUnknown {
+		t.Errorf("expected the unknown entry to come last, got %s", last.code)
+	}
+}
+
+func TestValidateErrorCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes map[int]errorCode
+		valid bool
+	}{
+		{"empty", nil, true},
+		{"good", map[int]errorCode{2: {Reason: "probe_shorted", Severity: severityCritical}}, true},
+		{"missing reason", map[int]errorCode{2: {Severity: severityCritical}}, false},
+		{"unknown reason", map[int]errorCode{2: {Reason: errorCodeUnknown, Severity: severityCritical}}, false},
+		{"bad severity", map[int]errorCode{2: {Reason: "probe_shorted", Severity: "panic"}}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateErrorCodes(tt.codes); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -20,18 +24,51 @@ import (
 const (
 	defaultListenAddress    = ":10010"
 	defaultWebTelemetryPath = "/metrics"
+	defaultWebProbePath     = "/probe"
+	defaultMaxStaleness     = "1m"
+	defaultHistoryRetention = "720h"
+	defaultHistoryRes       = "10s"
 	httpReadTimeout         = 12 * time.Second
+	shutdownTimeout         = 5 * time.Second
 )
 
 var (
+	configFile = kingpin.Flag(
+		"config.file",
+		"Path to a YAML file describing one or more Herpstat SpyderWebs.",
+	).PlaceHolder("herpstat.yml").String()
 	debug = kingpin.Flag(
 		"debug",
 		"Enable debug logging. It's very noisy!",
 	).Default("false").Bool()
+	historyPath = kingpin.Flag(
+		"history.path",
+		"Directory where a history of every reading is kept, and served from /api/v1/history. Leave this empty to disable it.",
+	).PlaceHolder("history").String()
+	historyRetention = kingpin.Flag(
+		"history.retention",
+		"How long readings are kept in the history.",
+	).Default(defaultHistoryRetention).Duration()
+	historyResolution = kingpin.Flag(
+		"history.resolution",
+		"How often readings are kept in the history. Anything polled more often than this overwrites the last reading.",
+	).Default(defaultHistoryRes).Duration()
 	herpstatAddress = kingpin.Flag(
 		"herpstat.address",
-		"Your Herpstat SpyderWeb's address.",
-	).Required().PlaceHolder("1.2.3.4").String()
+		"Your Herpstat SpyderWeb's address. Leave this empty if you're only using the probe endpoint.",
+	).PlaceHolder("1.2.3.4").String()
+	herpstatMaxStaleness = kingpin.Flag(
+		"herpstat.max-staleness",
+		"Stop exporting a device's readings once its data is older than this. 0 disables this.",
+	).Default(defaultMaxStaleness).Duration()
+	herpstatTemperatureUnit = kingpin.Flag(
+		"herpstat.temperature-unit",
+		"Which unit your Herpstat SpyderWeb reports temperatures in. Everything is exported in Celsius.",
+	).Default(string(unitAuto)).Enum(string(unitAuto), string(unitFahrenheit), string(unitCelsius))
+	stateFile = kingpin.Flag(
+		"state.file",
+		"Path to a file where energy, duty cycle and schedule compliance accounting is saved, so that it survives restarts. Leave this empty to keep it in memory.",
+	).PlaceHolder("herpstat-state.json").String()
 	webDisableExporterMetrics = kingpin.Flag(
 		"web.disable-exporter-metrics",
 		"Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).",
@@ -40,6 +77,10 @@ var (
 		"web.telemetry-path",
 		"Path under which to expose metrics.",
 	).Default(defaultWebTelemetryPath).String()
+	webProbePath = kingpin.Flag(
+		"web.probe-path",
+		"Path under which to expose metrics for any Herpstat SpyderWeb given via ?target=.",
+	).Default(defaultWebProbePath).String()
 
 	webFlags = kingpinflag.AddFlags(kingpin.CommandLine, defaultListenAddress)
 
@@ -50,10 +91,21 @@ var (
 type Exporter struct {
 	herpstat *herpstat
 	metrics  *metrics
+
+	// pollOnScrape is set for /probe targets that aren't being polled in the background by [exporter.herpstat.run]
+	pollOnScrape bool
+}
+
+// newExporter creates an [exporter.Exporter] for a single Herpstat SpyderWeb
+func newExporter(device *deviceConfig) *Exporter {
+	return &Exporter{
+		herpstat: newHerpstat(device),
+		metrics:  newMetrics(device.Labels),
+	}
 }
 
-// Run function starts an HTTP server that listens on [exporter.webTelemetryPath] and exposes
-// Prometheus metrics.
+// Run function starts an HTTP server that listens on [exporter.webTelemetryPath] and [exporter.webProbePath] and
+// exposes Prometheus metrics.
 func Run() {
 	kingpin.CommandLine.DefaultEnvars()
 	kingpin.Parse()
@@ -69,14 +121,132 @@ func Run() {
 	}
 
 	level.Info(logger).Log("msg", "Starting Herpstat SpyderWeb Exporter")
-	level.Info(logger).Log("msg", "Herpstat URL", "url", fmt.Sprintf(rawstatusURL, *herpstatAddress))
+
+	// everything running in the background is stopped on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &config{}
+
+	if *configFile != "" {
+		var err error
+
+		if c, err = loadConfig(*configFile); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+	}
+
+	if *herpstatAddress != "" {
+		c.Devices = append(c.Devices, &deviceConfig{Address: *herpstatAddress})
+	}
+
+	if err := c.validate(); err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
+	}
+
+	if len(c.Devices) == 0 {
+		level.Info(logger).Log("msg", fmt.Sprintf("No devices configured. Only serving devices via %s?target=", *webProbePath))
+	}
+
+	errorCodes = newErrorCatalog(c.ErrorCodes)
+
+	if len(c.ErrorCodes) == 0 {
+		level.Info(logger).Log("msg", "No error_codes in the config file, so every output error other than 0 is exported as unknown")
+	}
+
+	var listeners []pollListener
+
+	if len(c.Alerts.Rules) > 0 {
+		level.Info(logger).Log("msg", fmt.Sprintf("Evaluating %d alert rule(s)", len(c.Alerts.Rules)))
+
+		alerter := newAlerter(&c.Alerts)
+		listeners = append(listeners, alerter)
+
+		go alerter.run(ctx)
+	}
+
+	var history *historyStore
+
+	if *historyPath != "" {
+		var err error
+
+		if history, err = newHistoryStore(*historyPath, *historyRetention, *historyResolution); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+
+		level.Info(logger).Log("msg", "Keeping a history of every reading", "path", *historyPath, "retention", *historyRetention, "resolution", *historyResolution)
+
+		listeners = append(listeners, history)
+	}
+
+	var publisher *mqttPublisher
+
+	if c.MQTT.enabled() {
+		var err error
+
+		if publisher, err = connectMQTT(&c.MQTT); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+
+		level.Info(logger).Log("msg", "Publishing readings to MQTT", "broker", c.MQTT.Broker, "topic_prefix", c.MQTT.TopicPrefix)
+
+		listeners = append(listeners, publisher)
+	}
 
 	// create a new, clean prometheus registry without any exporter metrics
 	registry := prometheus.NewRegistry()
-	registry.MustRegister(&Exporter{
-		herpstat: newHerpstat(),
-		metrics:  newMetrics(),
-	})
+	exporters := make([]*Exporter, 0, len(c.Devices))
+	trips := newSafetyRelayTrips()
+	names := newSystemNames()
+	state := newStateStore(*stateFile)
+
+	if *stateFile != "" {
+		if err := state.load(); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+	}
+
+	// configured names always win over a nickname that happens to match one of them
+	for _, device := range c.Devices {
+		if device.Name != "" {
+			names.claim(device.Address, device.Name)
+		}
+	}
+
+	for _, device := range c.Devices {
+		level.Info(logger).Log("msg", "Herpstat URL", "url", fmt.Sprintf(rawstatusURL, device.Address))
+
+		e := newExporter(device)
+		e.herpstat.listeners = listeners
+		e.herpstat.trips = trips
+		e.herpstat.names = names
+		state.register(device.Address, e.herpstat.usage, e.herpstat.schedule)
+		registry.MustRegister(e)
+		exporters = append(exporters, e)
+
+		go e.herpstat.run(ctx)
+	}
+
+	// the state is saved one last time on shutdown, which has to finish before we exit
+	saved := make(chan struct{})
+
+	if *stateFile != "" {
+		go func() {
+			state.run(ctx)
+			close(saved)
+		}()
+	} else {
+		close(saved)
+	}
+
+	if publisher != nil {
+		go publisher.run(ctx, exporters)
+	}
 
 	// add the exporter metrics if requested
 	if *debug || !*webDisableExporterMetrics {
@@ -91,10 +261,40 @@ func Run() {
 	}
 
 	http.Handle(*webTelemetryPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	http.Handle(*webProbePath, probeHandler(newTargets(exporters...)))
+	http.Handle(safetyRelayTripsPath, trips.handler())
+	http.Handle(devicesPath, devicesHandler(exporters))
+	http.Handle(devicesPath+"/", devicesHandler(exporters))
+
+	if history != nil {
+		http.Handle(historyQueryPath, history.handler())
+	}
+
+	// the metrics can be served from / if someone really wants to, in which case there's no room for the dashboard
+	if *webTelemetryPath != "/" {
+		http.Handle("/", newDashboard(exporters, history).handler())
+	}
 
 	server := &http.Server{ReadTimeout: httpReadTimeout}
-	if err := web.ListenAndServe(server, webFlags, logger); err != nil {
+
+	go func() {
+		<-ctx.Done()
+		stop()
+
+		level.Info(logger).Log("msg", "Shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			level.Error(logger).Log("msg", "unable to shut down cleanly", "err", err)
+		}
+	}()
+
+	if err := web.ListenAndServe(server, webFlags, logger); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		level.Error(logger).Log("err", err)
 		os.Exit(1)
 	}
+
+	<-saved
 }
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestMain(m *testing.M) {
+	logger = log.NewNopLogger()
+
+	os.Exit(m.Run())
+}
+
+// readFixture reads a /RAWSTATUS body from testdata/
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("unable to read fixture %s: %s", name, err)
+	}
+
+	return data
+}
+
+// fixtureAddress is the address given to every device that serves a fixture
+const fixtureAddress = "herpstat.test"
+
+// fixtureTransport answers every request with the same /RAWSTATUS body, without touching the network
+type fixtureTransport []byte
+
+func (f fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	rec.Write(f) //nolint:errcheck // writing to a recorder can't fail
+
+	return rec.Result(), nil
+}
+
+// newFixtureExporter creates an [exporter.Exporter] whose device serves the given fixture, then polls it once
+func newFixtureExporter(t *testing.T, name string) *Exporter {
+	t.Helper()
+
+	e := newExporter(newDeviceConfig(fixtureAddress))
+	e.herpstat.client.Transport = fixtureTransport(readFixture(t, name))
+
+	if !e.herpstat.refresh() {
+		t.Fatalf("unable to poll fixture %s", name)
+	}
+
+	return e
+}
+
+// newTestInfo returns an [exporter.info] for the given system with the given output as its only output, numbered 1.
+// The system reports in the same unit as the output.
+func newTestInfo(s system, o output) *info {
+	o.ID = "1"
+	s.unit = o.unit
+
+	return &info{system: &s, outputs: &[]output{o}}
+}
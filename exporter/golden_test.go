@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden .prom files in testdata/golden")
+
+// volatileMetrics change on every run, so they're left out of the golden files
+var volatileMetrics = map[string]bool{
+	"herpstat_last_poll_timestamp_seconds":                 true,
+	"herpstat_last_successful_poll_timestamp_seconds":      true,
+	"herpstat_poll_age_seconds":                            true,
+	"herpstat_poll_duration_seconds":                       true,
+	"herpstat_output_ramp_session_start_timestamp_seconds": true,
+}
+
+// TestGolden polls every /RAWSTATUS body in testdata/golden and compares the resulting metrics against the matching
+// .prom file. The synthetic-* bodies are hand-written rather than captured, and the .prom files are snapshots of the
+// exporter's own output, so this only catches changes. Run `go test ./exporter -run TestGolden -update` after adding
+// a new body.
+func TestGolden(t *testing.T) {
+	bodies, err := filepath.Glob(filepath.Join("testdata", "golden", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bodies) == 0 {
+		t.Fatal("no /RAWSTATUS bodies found in testdata/golden")
+	}
+
+	for _, body := range bodies {
+		body := body
+		name := strings.TrimSuffix(filepath.Base(body), ".json")
+
+		t.Run(name, func(t *testing.T) {
+			e := newFixtureExporter(t, filepath.Join("golden", filepath.Base(body)))
+			golden := strings.TrimSuffix(body, ".json") + ".prom"
+			names := stableMetricNames(t, e)
+
+			if *update {
+				writeGolden(t, e, golden, names)
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read %s (run with -update to create it): %s", golden, err)
+			}
+
+			if err := testutil.CollectAndCompare(e, bytes.NewReader(expected), names...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestGoldenFirmwareCaptures makes the lack of real firmware captures show up in verbose test output. Until there are
+// some, TestGolden only proves that the exporter's output hasn't changed, not that it understands any real firmware.
+func TestGoldenFirmwareCaptures(t *testing.T) {
+	captures, err := filepath.Glob(filepath.Join("testdata", "golden", "firmware-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(captures) == 0 {
+		t.Skip("no real firmware captures in testdata/golden yet. see the README's Golden Files section to add one")
+	}
+
+	for _, capture := range captures {
+		if _, err := os.Stat(strings.TrimSuffix(capture, ".json") + ".prom"); err != nil {
+			t.Errorf("%s doesn't have any expected output (run with -update to create it): %s", capture, err)
+		}
+	}
+}
+
+// stableMetricNames returns the names of every metric that the exporter collects, minus the volatile ones
+func stableMetricNames(t *testing.T, c prometheus.Collector) []string {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+
+	names := []string{}
+
+	for _, family := range families {
+		if !volatileMetrics[family.GetName()] {
+			names = append(names, family.GetName())
+		}
+	}
+
+	return names
+}
+
+func writeGolden(t *testing.T, c prometheus.Collector, path string, names []string) {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var buf bytes.Buffer
+
+	for _, family := range families {
+		if !wanted[family.GetName()] {
+			continue
+		}
+
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatalf("unable to encode %s: %s", family.GetName(), err)
+		}
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("unable to write %s: %s", path, err)
+	}
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	pollResultSuccess   = "success"
+	pollResultHTTPError = "http_error"
+	pollResultBadJSON   = "bad_json"
+)
+
+// polls can take anywhere from a few milliseconds up to several timeouts plus retry waits
+var pollDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30}
+
+// health keeps track of how well the exporter is able to talk to a single Herpstat SpyderWeb. Unlike [exporter.metrics],
+// these are stateful and are updated by [exporter.herpstat] as it polls. Every metric has a "target" label with the
+// device's address, so they're available even if the device has never been successfully polled.
+type health struct {
+	up                 prometheus.Gauge
+	lastSuccessfulPoll prometheus.Gauge
+	pollDuration       prometheus.Histogram
+	pollAttempts       *prometheus.CounterVec
+	servedFromCache    prometheus.Counter
+	parseRepairs       *prometheus.CounterVec
+	invalidReadings    *prometheus.CounterVec
+}
+
+// newHealth creates all of the health metrics for the given device
+func newHealth(device *deviceConfig) *health {
+	constLabels := prometheus.Labels{"target": device.Address}
+	for name, value := range device.Labels {
+		constLabels[name] = value
+	}
+
+	h := &health{
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "up",
+			Help:        "Was the last poll of the Herpstat successful, with data that isn't stale?",
+			ConstLabels: constLabels,
+		}),
+		lastSuccessfulPoll: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "last_successful_poll_timestamp_seconds",
+			Help:        "When the Herpstat was last successfully polled, in seconds since the epoch.",
+			ConstLabels: constLabels,
+		}),
+		pollDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "poll_duration_seconds",
+			Help:        "How long it took to poll the Herpstat, including any retries.",
+			ConstLabels: constLabels,
+			Buckets:     pollDurationBuckets,
+		}),
+		pollAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "poll_attempts_total",
+			Help:        "Number of attempts made to poll the Herpstat, by result.",
+			ConstLabels: constLabels,
+		}, []string{"result"}),
+		servedFromCache: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "scrape_served_from_cache_total",
+			Help:        "Number of scrapes that were served previously polled data because the Herpstat couldn't be polled.",
+			ConstLabels: constLabels,
+		}),
+		parseRepairs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "parse_repairs_total",
+			Help:        "Number of repairs made to invalid JSON from the Herpstat, by kind.",
+			ConstLabels: constLabels,
+		}, []string{"kind"}),
+		invalidReadings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "invalid_readings_total",
+			Help:        "Number of readings from the Herpstat that were rejected by validation, by field and reason.",
+			ConstLabels: constLabels,
+		}, []string{"field", "reason"}),
+	}
+
+	// make sure that every result shows up, even before it happens
+	for _, result := range []string{pollResultSuccess, pollResultHTTPError, pollResultBadJSON} {
+		h.pollAttempts.WithLabelValues(result)
+	}
+
+	for _, kind := range repairKinds {
+		h.parseRepairs.WithLabelValues(kind)
+	}
+
+	for _, field := range validationFields {
+		for _, reason := range validationReasons {
+			h.invalidReadings.WithLabelValues(field, reason)
+		}
+	}
+
+	return h
+}
+
+// recordAttempt counts a single poll attempt with the given result
+func (h *health) recordAttempt(result string) {
+	h.pollAttempts.WithLabelValues(result).Inc()
+}
+
+// recordPoll records how long an entire poll took and when it last succeeded. [exporter.health.up] is set at
+// scrape time instead, since data can go stale in between polls.
+func (h *health) recordPoll(started time.Time, ok bool) {
+	h.pollDuration.Observe(time.Since(started).Seconds())
+
+	if ok {
+		h.lastSuccessfulPoll.Set(float64(time.Now().UnixNano()) / float64(time.Second))
+	}
+}
+
+func (h *health) collect(ch chan<- prometheus.Metric) {
+	h.up.Collect(ch)
+	h.lastSuccessfulPoll.Collect(ch)
+	h.pollDuration.Collect(ch)
+	h.pollAttempts.Collect(ch)
+	h.servedFromCache.Collect(ch)
+	h.parseRepairs.Collect(ch)
+	h.invalidReadings.Collect(ch)
+}
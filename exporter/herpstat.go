@@ -6,96 +6,276 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log/level"
 )
 
-const (
-	pollAttempts  = 3
-	pollInterval  = 10 * time.Second
-	pollRetryWait = 3 * time.Second
-	rawstatusURL  = "http://%s/RAWSTATUS"
-)
+const rawstatusURL = "http://%s/RAWSTATUS"
+
+// pollListener is anything that wants to know about every successful poll, such as [exporter.alerter]. Listeners are
+// called from the polling goroutine and shouldn't block.
+type pollListener interface {
+	polled(device *deviceConfig, info *info, at time.Time)
+}
 
+// herpstat polls a single Herpstat SpyderWeb. The most recently polled [exporter.info] is kept as a snapshot that
+// can be read at any time via [exporter.herpstat.snapshot], regardless of whether a poll is in progress.
 type herpstat struct {
+	sync.Mutex
+
+	// polling makes sure that only one poll is ever in flight per device
+	polling sync.Mutex
+
 	NextAllowedPoll time.Time
+	device          *deviceConfig
+	client          *http.Client
+	health          *health
+	validator       *validator
+	trips           *safetyRelayTrips
+	names           *systemNames
+	ramps           *rampTracker
+	usage           *usageTracker
+	schedule        *scheduleTracker
 	info            *info
+	lastPoll        time.Time
+	lastReset       time.Time
+	relayTrips      float64
+	up              bool
+	listeners       []pollListener
+
+	// unit is the temperature unit that auto-detection has latched onto, if any. See [exporter.detectTemperatureUnit].
+	unit temperatureUnit
 }
 
-// newHerpstat returns a new instance of the herpstat struct.
-// [exporter.herpstat.nextAllowedPoll] is set to 10 seconds in the past to ensure that the first [exporter.herpstat.pollingTooQuickly()]
-// call will return true
-func newHerpstat() *herpstat {
-	return &herpstat{
-		NextAllowedPoll: time.Now().Add(-pollInterval),
-		info: &info{
-			system:  &system{},
-			outputs: &[]output{},
-		},
+// newHerpstat returns a new instance of the herpstat struct for the given Herpstat SpyderWeb.
+// [exporter.herpstat.nextAllowedPoll] is set to one poll interval in the past to ensure that the first
+// [exporter.herpstat.pollingTooQuickly()] call will return false
+func newHerpstat(device *deviceConfig) *herpstat {
+	h := &herpstat{
+		NextAllowedPoll: time.Now().Add(-device.PollInterval),
+		device:          device,
+		client:          &http.Client{Timeout: device.Timeout},
+		health:          newHealth(device),
+		info:            newInfo(),
+		ramps:           newRampTracker(),
+		usage:           newUsageTracker(device),
+		schedule:        newScheduleTracker(device),
 	}
+
+	h.validator = newValidator(device.Validation, h.health.invalidReadings)
+
+	return h
 }
 
-// Polls the Herpstat SpyderWeb device to retrieve its status info, storing it in [herpstat.exporter.info]. They can
-// sometimes be a little finicky and come back with invalid JSON data. If that happens, we'll try polling a total of
-// three times, waiting three seconds in between each poll.
+// run polls the Herpstat SpyderWeb in the background every [exporter.deviceConfig.PollInterval] until the context
+// is cancelled. This keeps a slow or flaky device from ever blocking a Prometheus scrape.
+func (h *herpstat) run(ctx context.Context) {
+	level.Info(logger).Log("msg", fmt.Sprintf("Polling every %.0f seconds", h.device.PollInterval.Seconds()), "address", h.device.Address)
+
+	ticker := time.NewTicker(h.device.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		h.refresh()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// snapshot returns the most recently polled [exporter.info] along with when it was polled and whether the last
+// poll was successful. The time will be zero if the device has never been successfully polled.
+func (h *herpstat) snapshot() (*info, time.Time, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.info, h.lastPoll, h.up
+}
+
+// isStale checks whether data polled at the given time is older than [exporter.deviceConfig.MaxStaleness]
+func (h *herpstat) isStale(polled time.Time) bool {
+	if h.device.MaxStaleness <= 0 {
+		return false
+	}
+
+	return time.Since(polled) > h.device.MaxStaleness
+}
+
+// Polls the Herpstat SpyderWeb device on demand, unless it was already polled within the last
+// [exporter.deviceConfig.PollInterval], in which case the previous snapshot is left alone. Returns false if the
+// device couldn't be polled.
 func (h *herpstat) poll() bool {
+	h.polling.Lock()
+	defer h.polling.Unlock()
+
 	if h.pollingTooQuickly() {
-		level.Warn(logger).Log("msg", fmt.Sprintf("Polling too quickly! Please set polling interval to %.0f seconds.", pollInterval.Seconds()))
-		level.Warn(logger).Log("msg", fmt.Sprintf("See http://%s/handleAdminControls for more information.", *herpstatAddress))
+		level.Warn(logger).Log("msg", fmt.Sprintf("Polling too quickly! Please set polling interval to %.0f seconds.", h.device.PollInterval.Seconds()))
+		level.Warn(logger).Log("msg", fmt.Sprintf("See http://%s/handleAdminControls for more information.", h.device.Address))
 
+		// the last poll worked, so this doesn't count towards herpstat_scrape_served_from_cache_total
 		return true
 	}
 
+	return h.pollDevice()
+}
+
+// refresh polls the Herpstat SpyderWeb device without checking [exporter.herpstat.pollingTooQuickly], since
+// [exporter.herpstat.run] is already in charge of the schedule.
+func (h *herpstat) refresh() bool {
+	h.polling.Lock()
+	defer h.polling.Unlock()
+
+	return h.pollDevice()
+}
+
+// Retrieves the Herpstat SpyderWeb's status info, storing it in [herpstat.exporter.info]. They can sometimes be a
+// little finicky and come back with invalid JSON data. If that happens, we'll try polling a total of
+// [exporter.deviceConfig.PollAttempts] times (3 by default), waiting [exporter.deviceConfig.PollRetryWait]
+// (3 seconds by default) in between each poll.
+func (h *herpstat) pollDevice() bool {
+	started := time.Now()
 	retried := false
 
-	// herpstats can sometimes come back with weird data. we'll retry a total of three times, waiting
-	// 3 seconds in between attempts if that happens.
-	for i := 1; i <= pollAttempts; i++ {
-		level.Debug(logger).Log("msg", fmt.Sprintf("poll attempt %d/%d", i, pollAttempts))
+	// herpstats can sometimes come back with weird data. we'll retry a few times, waiting a few seconds in
+	// between attempts if that happens.
+	for i := 1; i <= h.device.PollAttempts; i++ {
+		level.Debug(logger).Log("msg", fmt.Sprintf("poll attempt %d/%d", i, h.device.PollAttempts), "address", h.device.Address)
 
 		rawstatus := h.getRawstatus()
 		if rawstatus == nil {
 			retried = true
 
-			maybeWait(i, pollAttempts)
+			h.health.recordAttempt(pollResultHTTPError)
+
+			h.maybeWait(i)
 
 			continue
 		}
 
-		if err := json.Unmarshal(*rawstatus, &h.info); err != nil {
+		polled, err := h.unmarshal(*rawstatus)
+		if err != nil {
 			retried = true
 
 			level.Warn(logger).Log("msg", fmt.Sprintf("unable to unmarshal JSON: %s", err.Error()))
 			level.Warn(logger).Log("msg", *rawstatus)
-			maybeWait(i, pollAttempts)
+			h.health.recordAttempt(pollResultBadJSON)
+			h.maybeWait(i)
 
 			continue
 		}
 
+		unit, latch := detectTemperatureUnit(h.device.TemperatureUnit, h.unit, polled.system)
+
+		switch {
+		case latch:
+			level.Info(logger).Log("msg", "Detected temperature unit", "address", h.device.Address, "unit", unit)
+			h.unit = unit
+		case unit == unitUnknown:
+			level.Warn(logger).Log("msg", "Unable to tell which temperature unit the device uses from its internal temperature yet. Temperatures won't be exported until it can, or until temperature_unit is set.", "address", h.device.Address, "internal_temperature", polled.system.Temp)
+		}
+
+		polled.setTemperatureUnit(unit)
+
+		// the configured name takes precedence over whatever nickname the device gave itself
+		if h.device.Name != "" {
+			polled.system.Name = h.device.Name
+		}
+
+		polled.system.Name = h.names.claim(h.device.Address, polled.system.Name)
+
 		if retried {
-			level.Info(logger).Log("msg", "Successfully unmarshalled JSON after %d attempts", i)
+			level.Info(logger).Log("msg", fmt.Sprintf("Successfully unmarshalled JSON after %d attempts", i))
+		}
+
+		now := time.Now()
+
+		h.validator.validate(polled, now)
+
+		h.Lock()
+		previous, polledBefore := h.info, !h.lastPoll.IsZero()
+		h.info = polled
+		h.lastPoll = now
+		h.up = true
+		h.Unlock()
+
+		// there's no way of knowing whether the relay tripped before we started watching it
+		if polledBefore {
+			h.checkSafetyRelay(previous, polled, now)
+			h.checkPowerResets(previous, polled, now)
 		}
 
-		// success! we can poll again in 10 seconds
-		h.NextAllowedPoll = time.Now().Add(pollInterval)
+		h.ramps.update(h.device, polled, now)
+		h.usage.update(polled, now)
+		h.schedule.update(polled, now)
+
+		h.health.recordAttempt(pollResultSuccess)
+		h.health.recordPoll(started, true)
+
+		for _, l := range h.listeners {
+			l.polled(h.device, polled, now)
+		}
+
+		// success! we can poll again after the poll interval
+		h.NextAllowedPoll = now.Add(h.device.PollInterval)
 
 		return true
 	}
 
-	level.Error(logger).Log("msg", fmt.Sprintf("unable to get data from device after %d attempts", pollAttempts))
+	level.Error(logger).Log("msg", fmt.Sprintf("unable to get data from device after %d attempts", h.device.PollAttempts), "address", h.device.Address)
+
+	h.Lock()
+	h.up = false
+	h.Unlock()
+
+	h.health.recordPoll(started, false)
 
 	return false
 }
 
-// Waits for [herpstat.exporter.pollRetryWait] (3 seconds), trying again if we're not done with the loop.
-func maybeWait(i, limit int) {
-	if i > limit {
+// unmarshal parses a /RAWSTATUS response. If it isn't valid JSON, we'll try to salvage whatever we can from it via
+// [exporter.repairRawstatus] rather than throwing the whole thing away.
+func (h *herpstat) unmarshal(rawstatus []byte) (*info, error) {
+	polled := newInfo()
+
+	err := json.Unmarshal(rawstatus, polled)
+	if err == nil {
+		return polled, nil
+	}
+
+	level.Debug(logger).Log("msg", "trying to repair invalid JSON", "err", err)
+
+	repaired, repairs, repairErr := repairRawstatus(rawstatus)
+	if repairErr != nil {
+		return nil, err
+	}
+
+	polled = newInfo()
+	if repairErr = json.Unmarshal(repaired, polled); repairErr != nil {
+		return nil, err
+	}
+
+	level.Warn(logger).Log("msg", "Repaired invalid JSON from device", "address", h.device.Address, "repairs", fmt.Sprintf("%v", repairs))
+
+	for _, kind := range repairs {
+		h.health.parseRepairs.WithLabelValues(kind).Inc()
+	}
+
+	return polled, nil
+}
+
+// Waits for [exporter.deviceConfig.PollRetryWait] (3 seconds by default), but only if we're going to try again.
+func (h *herpstat) maybeWait(i int) {
+	if i >= h.device.PollAttempts {
 		return
 	}
 
-	level.Warn(logger).Log("msg", fmt.Sprintf("Waiting %.0f seconds before trying again...", pollRetryWait.Seconds()))
-	time.Sleep(pollRetryWait)
+	level.Warn(logger).Log("msg", fmt.Sprintf("Waiting %.0f seconds before trying again...", h.device.PollRetryWait.Seconds()))
+	time.Sleep(h.device.PollRetryWait)
 }
 
 // checks whether the next allowed poll time [herpstat.exporter.nextAllowedPoll] is after the current time
@@ -109,24 +289,33 @@ func (h *herpstat) pollingTooQuickly() bool {
 // Performs an HTTP request to the `/RAWSTATUS` endpoint of the Herpstat SpyderWeb and returns its raw, byte-encoded
 // body.
 func (h *herpstat) getRawstatus() *[]byte {
-	level.Debug(logger).Log("msg", "getting data from herpstat")
+	level.Debug(logger).Log("msg", "getting data from herpstat", "address", h.device.Address)
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fmt.Sprintf(rawstatusURL, *herpstatAddress), http.NoBody)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, fmt.Sprintf(rawstatusURL, h.device.Address), http.NoBody)
 	if err != nil {
 		level.Error(logger).Log("msg", "unable to make new request object:", "err", err)
 		return nil
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	if h.device.Username != "" {
+		req.SetBasicAuth(h.device.Username, h.device.Password)
+	}
+
+	resp, err := h.client.Do(req)
 	if err != nil {
 		level.Error(logger).Log("msg", "problem making request", "err", err)
 		return nil
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		level.Error(logger).Log("msg", "unexpected response from herpstat", "status", resp.Status)
+		return nil
+	}
+
 	rawStatus, err := io.ReadAll(resp.Body)
 	if err != nil {
-		level.Error(logger).Log("msg", "problem reading response body", err)
+		level.Error(logger).Log("msg", "problem reading response body", "err", err)
 		return nil
 	}
 
@@ -0,0 +1,334 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newSimulatedExporter creates an [exporter.Exporter] for a simulated device that retries quickly
+func newSimulatedExporter(t *testing.T, c simulator.Config) (*Exporter, *simulator.Device) {
+	t.Helper()
+
+	device := simulator.New(c)
+
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	d := newDeviceConfig(strings.TrimPrefix(server.URL, "http://"))
+	d.PollRetryWait = time.Millisecond
+	d.Timeout = time.Second
+
+	return newExporter(d), device
+}
+
+func TestPollRetriesInvalidJSON(t *testing.T) {
+	e, device := newSimulatedExporter(t, simulator.Config{})
+	device.CorruptNext(2)
+
+	if !e.herpstat.refresh() {
+		t.Fatal("expected the poll to succeed on its last attempt")
+	}
+
+	if polls := device.Polls(); polls != 3 {
+		t.Errorf("expected 3 polls, got %d", polls)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.pollAttempts.WithLabelValues(pollResultBadJSON)); got != 2 {
+		t.Errorf("expected 2 bad_json attempts, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.pollAttempts.WithLabelValues(pollResultSuccess)); got != 1 {
+		t.Errorf("expected 1 successful attempt, got %v", got)
+	}
+}
+
+func TestPollGivesUp(t *testing.T) {
+	e, device := newSimulatedExporter(t, simulator.Config{})
+
+	if !e.herpstat.refresh() {
+		t.Fatal("expected the first poll to succeed")
+	}
+
+	lastSuccessfulPoll := testutil.ToFloat64(e.herpstat.health.lastSuccessfulPoll)
+	if lastSuccessfulPoll == 0 {
+		t.Error("expected the successful poll to be recorded")
+	}
+
+	device.CorruptNext(defaultPollAttempts)
+
+	if e.herpstat.refresh() {
+		t.Fatal("expected the poll to fail")
+	}
+
+	_, lastPoll, up := e.herpstat.snapshot()
+	if up {
+		t.Error("expected the device to be down")
+	}
+
+	if lastPoll.IsZero() {
+		t.Error("expected the previous data to be kept")
+	}
+
+	// scraping now serves the data from the first poll
+	testutil.CollectAndCount(e)
+
+	if got := testutil.ToFloat64(e.herpstat.health.servedFromCache); got != 1 {
+		t.Errorf("expected 1 scrape to be served from the cache, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.lastSuccessfulPoll); got != lastSuccessfulPoll {
+		t.Errorf("expected the last successful poll to stay at %v, got %v", lastSuccessfulPoll, got)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.up); got != 0 {
+		t.Errorf("expected up to be 0, got %v", got)
+	}
+
+	// both polls are timed, whether or not they worked
+	m := &dto.Metric{}
+	if err := e.herpstat.health.pollDuration.Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("expected 2 poll durations, got %d", got)
+	}
+}
+
+func TestPollingTooQuicklyIsNotServedFromCache(t *testing.T) {
+	e, device := newSimulatedExporter(t, simulator.Config{})
+	e.pollOnScrape = true
+
+	testutil.CollectAndCount(e)
+	testutil.CollectAndCount(e)
+
+	if polls := device.Polls(); polls != 1 {
+		t.Errorf("expected the second scrape to be rate limited, got %d polls", polls)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.servedFromCache); got != 0 {
+		t.Errorf("expected rate limited scrapes not to count as served from the cache, got %v", got)
+	}
+}
+
+func TestRunPollsOnItsOwnSchedule(t *testing.T) {
+	e, device := newSimulatedExporter(t, simulator.Config{})
+	e.herpstat.device.PollInterval = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		e.herpstat.run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// the first poll happens right away
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, lastPoll, _ := e.herpstat.snapshot(); !lastPoll.IsZero() {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the device to be polled in the background")
+		}
+	}
+
+	// scrapes are served from the last poll instead of polling the device themselves
+	scrapes := 0
+	for stop := time.Now().Add(250 * time.Millisecond); time.Now().Before(stop); time.Sleep(10 * time.Millisecond) {
+		testutil.CollectAndCount(e)
+		scrapes++
+	}
+
+	// one poll right away, then one per tick. this allows for a tick either way to avoid being flaky.
+	if polls := device.Polls(); polls < 2 || polls > 4 {
+		t.Errorf("expected about 3 polls from the ticker over %d scrapes, got %d", scrapes, polls)
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.up); got != 1 {
+		t.Errorf("expected the device to be up, got %v", got)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	e, _ := newSimulatedExporter(t, simulator.Config{Delay: 200 * time.Millisecond})
+	e.herpstat.client.Timeout = 10 * time.Millisecond
+
+	if e.herpstat.refresh() {
+		t.Fatal("expected the poll to time out")
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.pollAttempts.WithLabelValues(pollResultHTTPError)); got != defaultPollAttempts {
+		t.Errorf("expected %d http_error attempts, got %v", defaultPollAttempts, got)
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	device := simulator.New(simulator.Config{Nickname: "Probed"})
+
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	handler := probeHandler(newTargets())
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe", http.NoBody))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected %d without a target, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+strings.TrimPrefix(server.URL, "http://"), http.NoBody))
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), `herpstat_system_info{firmware="2.10",ip="192.168.1.50",mac="24:0A:C4:00:00:01",outputs="4",system="Probed"} 1`) {
+		t.Errorf("expected the probed device's metrics, got:\n%s", body)
+	}
+}
+
+func TestProbeTargetEviction(t *testing.T) {
+	configured := newExporter(newDeviceConfig("10.0.0.1"))
+	targets := newTargets(configured)
+
+	first := targets.get("10.0.0.2")
+	if targets.get("10.0.0.2") != first {
+		t.Error("expected a target to be reused while it's being probed")
+	}
+
+	// idle targets are forgotten, but configured ones never are
+	targets.probed["10.0.0.2"].lastUsed = time.Now().Add(-probeTargetTTL - time.Second)
+
+	if targets.get("10.0.0.3"); len(targets.probed) != 1 || targets.probed["10.0.0.2"] != nil {
+		t.Errorf("expected the idle target to be forgotten, got %v", targets.probed)
+	}
+
+	if targets.get("10.0.0.1") != configured {
+		t.Error("expected the configured device to be reused")
+	}
+
+	// there's only ever so many at once, and the least recently probed ones go first
+	for i := 0; i < maxProbeTargets*2; i++ {
+		targets.get(fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+	}
+
+	if len(targets.probed) > maxProbeTargets {
+		t.Errorf("expected at most %d targets, got %d", maxProbeTargets, len(targets.probed))
+	}
+
+	if targets.probed["10.0.0.3"] != nil {
+		t.Error("expected the least recently probed target to be forgotten")
+	}
+}
+
+func TestPollRepairsInvalidJSON(t *testing.T) {
+	e := newExporter(newDeviceConfig(fixtureAddress))
+	e.herpstat.client.Transport = fixtureTransport(`{"system":{"nickname":"Snakes","numberofoutputs":2,"internaltemp":95},"output1":{"outputnickname":"Heat","probereadingTEMP":90,,"outputmode":"Proportional Heating"},"output2":{"outputnick`)
+
+	if !e.herpstat.refresh() {
+		t.Fatal("expected the repaired poll to succeed")
+	}
+
+	if got := testutil.ToFloat64(e.herpstat.health.pollAttempts.WithLabelValues(pollResultBadJSON)); got != 0 {
+		t.Errorf("expected no bad_json attempts, got %v", got)
+	}
+
+	for _, kind := range []string{repairTruncated, repairDuplicateCommas} {
+		if got := testutil.ToFloat64(e.herpstat.health.parseRepairs.WithLabelValues(kind)); got != 1 {
+			t.Errorf("expected 1 %s repair, got %v", kind, got)
+		}
+	}
+
+	info, _, _ := e.herpstat.snapshot()
+	if outputs := len(*info.outputs); outputs != 1 {
+		t.Errorf("expected 1 output to be salvaged, got %d", outputs)
+	}
+}
+
+func TestPollWithoutSystem(t *testing.T) {
+	for _, data := range []string{
+		`{"system":null,"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`,
+		`{"system":NaN,"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`,
+	} {
+		e := newExporter(newDeviceConfig(fixtureAddress))
+		e.herpstat.device.PollRetryWait = time.Millisecond
+		e.herpstat.client.Transport = fixtureTransport(data)
+
+		if e.herpstat.refresh() {
+			t.Errorf("expected polling %s to fail", data)
+		}
+
+		if got := testutil.ToFloat64(e.herpstat.health.pollAttempts.WithLabelValues(pollResultBadJSON)); got == 0 {
+			t.Errorf("expected %s to count as bad_json", data)
+		}
+	}
+}
+
+func TestPollRepairedReadingsAreInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		internal bool
+		probe    bool
+		unit     temperatureUnit
+	}{
+		{
+			// a NaN probe reading would otherwise be read as 0°F, which is right at the bottom of the valid range
+			name:     "probe",
+			data:     `{"system":{"numberofoutputs":1,"internaltemp":95},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":NaN}}`,
+			internal: true,
+			unit:     unitFahrenheit,
+		},
+		{
+			// and a NaN internal temperature would otherwise be read as 0, making the whole device look like Celsius
+			name: "internal temperature",
+			data: `{"system":{"numberofoutputs":1,"internaltemp":NaN},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`,
+			unit: unitUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newExporter(newDeviceConfig(fixtureAddress))
+			e.herpstat.client.Transport = fixtureTransport(tt.data)
+
+			if !e.herpstat.refresh() {
+				t.Fatal("expected the repaired poll to succeed")
+			}
+
+			info, _, _ := e.herpstat.snapshot()
+
+			if info.system.unit != tt.unit {
+				t.Errorf("expected the unit to be %s, got %s", tt.unit, info.system.unit)
+			}
+
+			if valid := info.system.invalid.valid(fieldInternalTemperature); valid != tt.internal {
+				t.Errorf("expected the internal temperature's validity to be %v, got %v", tt.internal, valid)
+			}
+
+			if _, valid := (*info.outputs)[0].probeReading(); valid != tt.probe {
+				t.Errorf("expected the probe reading's validity to be %v, got %v", tt.probe, valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,494 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// historyQueryPath is where the history is queried
+	historyQueryPath = "/api/v1/history"
+
+	// historyFieldSetpoint is the output's current setpoint. Every other field is one of the validation fields.
+	historyFieldSetpoint = "setpoint"
+
+	// every series file starts with a header, followed by a fixed number of records
+	historyMagic      = "HSRB"
+	historyVersion    = 1
+	historyHeaderSize = 16
+	historyRecordSize = 8
+
+	// queries without a from default to the last hour, and without a step are split into about this many points
+	defaultHistoryWindow = time.Hour
+	defaultHistoryPoints = 500
+
+	// the most points that a single query can return
+	maxHistoryPoints = 10000
+)
+
+var (
+	// historyOutputFields are recorded for every output, as long as it has them. Temperatures are in Celsius.
+	historyOutputFields = []string{fieldTemperature, fieldHumidity, fieldPower, historyFieldSetpoint}
+
+	// historySystemFields are recorded for the system itself, without an output
+	historySystemFields = []string{fieldInternalTemperature}
+)
+
+// historyStore is an optional on-disk ring buffer of every device's readings, for people who don't run Prometheus.
+// It listens for every successful poll (see [exporter.pollListener]) and keeps one file per system, output and
+// field in --history.path. Each file holds one record per --history.resolution for --history.retention, so it never
+// grows once it has been created.
+//
+// Each record is the slot that it was written in (the Unix time divided by the resolution) followed by its value,
+// both 32 bits. A record whose slot doesn't match the one being read is from a previous time around the ring.
+type historyStore struct {
+	sync.Mutex
+
+	dir        string
+	resolution time.Duration
+	slots      int64
+	series     map[string]*os.File
+}
+
+// historyPoint is a single, possibly downsampled, reading
+type historyPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// historyResponse is the JSON returned by [exporter.historyQueryPath]
+type historyResponse struct {
+	System string         `json:"system"`
+	Output string         `json:"output,omitempty"`
+	Field  string         `json:"field"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Step   float64        `json:"step"`
+	Points []historyPoint `json:"points"`
+}
+
+func newHistoryStore(dir string, retention, resolution time.Duration) (*historyStore, error) {
+	if resolution < time.Second || resolution%time.Second != 0 {
+		return nil, fmt.Errorf("history resolution must be a whole number of seconds, not %s", resolution)
+	}
+
+	if retention < resolution {
+		return nil, fmt.Errorf("history retention (%s) must be at least as long as its resolution (%s)", retention, resolution)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create history directory: %w", err)
+	}
+
+	return &historyStore{
+		dir:        dir,
+		resolution: resolution,
+		slots:      int64(retention / resolution),
+		series:     map[string]*os.File{},
+	}, nil
+}
+
+// polled records every output's readings. It implements [exporter.pollListener].
+func (s *historyStore) polled(device *deviceConfig, info *info, at time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	if info.system.invalid.valid(fieldInternalTemperature) {
+		s.record(info.system.Name, "", fieldInternalTemperature, info.system.unit.toCelsius(info.system.Temp), at)
+	}
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+
+		for _, field := range historyOutputFields {
+			if value, ok := o.historyValue(field); ok {
+				s.record(info.system.Name, o.ID, field, value, at)
+			}
+		}
+	}
+}
+
+// historyValue returns one of the fields that's kept in the history, as long as the output has it and it looks sane
+func (o *output) historyValue(field string) (float64, bool) {
+	switch field {
+	case fieldTemperature:
+		return o.unit.toCelsius(o.ProbeTemp), o.mode.hasTemperatureProbe() && o.invalid.valid(fieldTemperature)
+	case fieldHumidity:
+		return o.ProbeHumidity, o.mode.hasHumidityProbe() && o.invalid.valid(fieldHumidity)
+	case fieldPower:
+		return o.Power, o.invalid.valid(fieldPower)
+	case historyFieldSetpoint:
+		if o.Setpoint == nil || !o.mode.hasProbe() || !o.hasKnownUnit() {
+			return 0, false
+		}
+
+		return o.setting(*o.Setpoint), true
+	default:
+		return 0, false
+	}
+}
+
+// record writes a single reading into its series, creating the series if this is the first time it has been seen
+func (s *historyStore) record(system, outputID, field string, value float64, at time.Time) {
+	f, err := s.open(system, outputID, field, true)
+	if err != nil {
+		level.Error(logger).Log("msg", "unable to open history", "system", system, "output", outputID, "field", field, "err", err)
+		return
+	}
+
+	slot := at.Unix() / int64(s.resolution/time.Second)
+
+	record := make([]byte, historyRecordSize)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(slot))
+	binary.LittleEndian.PutUint32(record[4:8], math.Float32bits(float32(value)))
+
+	if _, err := f.WriteAt(record, s.offset(slot)); err != nil {
+		level.Error(logger).Log("msg", "unable to write history", "system", system, "output", outputID, "field", field, "err", err)
+	}
+}
+
+// offset is where a slot's record lives in its series file
+func (s *historyStore) offset(slot int64) int64 {
+	return historyHeaderSize + (slot%s.slots)*historyRecordSize
+}
+
+// open returns a series' file. If it doesn't exist yet, it's only created if asked to. A file that was created with a
+// different resolution or retention is started over, since its records can't be lined up with the new ones.
+func (s *historyStore) open(system, outputID, field string, create bool) (*os.File, error) {
+	path, err := s.path(system, outputID, field)
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := s.series[path]; ok {
+		return f, nil
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && !create {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	header := s.header()
+	existing := make([]byte, historyHeaderSize)
+
+	if _, err := f.ReadAt(existing, 0); err != nil || string(existing) != string(header) {
+		if err == nil {
+			level.Warn(logger).Log("msg", "History was created with a different resolution or retention. Starting it over.", "path", path)
+		}
+
+		if err := s.reset(f, header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	s.series[path] = f
+
+	return f, nil
+}
+
+// reset empties a series file and sizes it to hold every slot
+func (s *historyStore) reset(f *os.File, header []byte) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	return f.Truncate(historyHeaderSize + s.slots*historyRecordSize)
+}
+
+// header identifies a series file along with the resolution and number of slots that it was created with
+func (s *historyStore) header() []byte {
+	header := make([]byte, historyHeaderSize)
+
+	copy(header[0:4], historyMagic)
+	binary.LittleEndian.PutUint32(header[4:8], historyVersion)
+	binary.LittleEndian.PutUint32(header[8:12], uint32(s.resolution/time.Second))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(s.slots))
+
+	return header
+}
+
+// path is where a series lives. System names are escaped since they come from the device, including any leading
+// dot so that a name like ".." can't be used to get out of the history directory.
+func (s *historyStore) path(system, outputID, field string) (string, error) {
+	name := "system"
+	if outputID != "" {
+		name = "output" + outputID
+	}
+
+	dir := url.PathEscape(system)
+	if strings.HasPrefix(dir, ".") {
+		dir = "%2E" + dir[1:]
+	}
+
+	path := filepath.Join(s.dir, dir, fmt.Sprintf("%s-%s.ring", url.PathEscape(name), url.PathEscape(field)))
+
+	if rel, err := filepath.Rel(s.dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("history for system %q would be outside of the history directory", system)
+	}
+
+	return path, nil
+}
+
+// query returns a series' readings between from and to, averaged over each step. Steps without any readings are
+// left out.
+func (s *historyStore) query(system, outputID, field string, from, to time.Time, step time.Duration) ([]historyPoint, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	points := []historyPoint{}
+
+	f, err := s.open(system, outputID, field, false)
+	if err != nil || f == nil {
+		return points, err
+	}
+
+	seconds := int64(s.resolution / time.Second)
+	last := to.Unix() / seconds
+	first := from.Unix() / seconds
+
+	// anything older than the retention has already been overwritten
+	if oldest := last - s.slots + 1; first < oldest {
+		first = oldest
+	}
+
+	if first > last {
+		return points, nil
+	}
+
+	records, err := s.read(f, first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		current      = -1
+		sum, samples float64
+	)
+
+	flush := func() {
+		if samples > 0 {
+			points = append(points, historyPoint{
+				Timestamp: from.Add(time.Duration(current) * step),
+				Value:     sum / samples,
+			})
+		}
+	}
+
+	for i := int64(0); i < int64(len(records))/historyRecordSize; i++ {
+		record := records[i*historyRecordSize : (i+1)*historyRecordSize]
+
+		slot := first + i
+		if binary.LittleEndian.Uint32(record[0:4]) != uint32(slot) {
+			continue
+		}
+
+		at := time.Unix(slot*seconds, 0)
+		if at.Before(from) {
+			continue
+		}
+
+		if bucket := int(at.Sub(from) / step); bucket != current {
+			flush()
+
+			current, sum, samples = bucket, 0, 0
+		}
+
+		sum += float64(math.Float32frombits(binary.LittleEndian.Uint32(record[4:8])))
+		samples++
+	}
+
+	flush()
+
+	return points, nil
+}
+
+// read returns the records for every slot from first to last, wrapping around the end of the file if need be
+func (s *historyStore) read(f *os.File, first, last int64) ([]byte, error) {
+	records := make([]byte, (last-first+1)*historyRecordSize)
+
+	start := first % s.slots
+	end := start + last - first + 1
+
+	if end <= s.slots {
+		_, err := f.ReadAt(records, s.offset(first))
+		return records, ignoreEOF(err)
+	}
+
+	split := (s.slots - start) * historyRecordSize
+
+	if _, err := f.ReadAt(records[:split], s.offset(first)); ignoreEOF(err) != nil {
+		return nil, err
+	}
+
+	_, err := f.ReadAt(records[split:], historyHeaderSize)
+
+	return records, ignoreEOF(err)
+}
+
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+// handler serves /api/v1/history?system=&output=&field=&from=&to=&step= as JSON. from and to can be RFC 3339 or
+// Unix timestamps, and default to the last hour. step can be a duration (eg: 5m) or a number of seconds, and defaults
+// to splitting the range into about [exporter.defaultHistoryPoints] points.
+func (s *historyStore) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		response := &historyResponse{
+			System: params.Get("system"),
+			Output: params.Get("output"),
+			Field:  params.Get("field"),
+		}
+
+		if err := s.parseQuery(response, params); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		step := time.Duration(response.Step * float64(time.Second))
+
+		points, err := s.query(response.System, response.Output, response.Field, response.From, response.To, step)
+		if err != nil {
+			level.Error(logger).Log("msg", "unable to read history", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, errors.New("unable to read history"))
+
+			return
+		}
+
+		response.Points = points
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// parseQuery checks a query's parameters and fills in its time range and step
+func (s *historyStore) parseQuery(response *historyResponse, params url.Values) error {
+	if response.System == "" {
+		return errors.New("system is required")
+	}
+
+	switch {
+	case isHistoryField(historySystemFields, response.Field):
+		if response.Output != "" {
+			return fmt.Errorf("%s is a system field, so it doesn't take an output", response.Field)
+		}
+	case isHistoryField(historyOutputFields, response.Field):
+		if response.Output == "" {
+			return fmt.Errorf("%s is an output field, so it needs an output", response.Field)
+		}
+	default:
+		return fmt.Errorf("unknown field (%q). it must be one of %v or %v", response.Field, historyOutputFields, historySystemFields)
+	}
+
+	var err error
+
+	if response.To, err = parseHistoryTime(params.Get("to"), time.Now()); err != nil {
+		return fmt.Errorf("invalid to: %w", err)
+	}
+
+	if response.From, err = parseHistoryTime(params.Get("from"), response.To.Add(-defaultHistoryWindow)); err != nil {
+		return fmt.Errorf("invalid from: %w", err)
+	}
+
+	if !response.From.Before(response.To) {
+		return errors.New("from must be before to")
+	}
+
+	span := response.To.Sub(response.From)
+
+	step, err := parseHistoryStep(params.Get("step"), span/defaultHistoryPoints)
+	if err != nil {
+		return fmt.Errorf("invalid step: %w", err)
+	}
+
+	// nothing is recorded more often than the resolution, so there's no point in a smaller step
+	if step < s.resolution {
+		step = s.resolution
+	}
+
+	if span/step > maxHistoryPoints {
+		return fmt.Errorf("step is too small. at most %d points can be returned", maxHistoryPoints)
+	}
+
+	response.Step = step.Seconds()
+
+	return nil
+}
+
+func isHistoryField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHistoryTime parses an RFC 3339 or Unix timestamp, falling back to a default if it's empty. Nothing can be
+// stored before the epoch, since readings are kept in slots counted from it.
+func parseHistoryTime(raw string, fallback time.Time) (time.Time, error) {
+	at := fallback
+
+	if raw != "" {
+		var err error
+
+		if seconds, parseErr := strconv.ParseFloat(raw, 64); parseErr == nil {
+			at = time.Unix(0, int64(seconds*float64(time.Second)))
+		} else if at, err = time.Parse(time.RFC3339, raw); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if at.Before(time.Unix(0, 0)) {
+		return time.Time{}, fmt.Errorf("%s is before 1970", at.UTC().Format(time.RFC3339))
+	}
+
+	return at, nil
+}
+
+// parseHistoryStep parses a duration or a number of seconds, falling back to a default if it's empty
+func parseHistoryStep(raw string, fallback time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(raw)
+}
@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	s, err := newHistoryStore(t.TempDir(), time.Hour, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+
+	// one reading every 10 seconds for 2 minutes: 0, 1, 2, ... 11
+	for i := 0; i < 12; i++ {
+		s.polled(nil, newTestInfo(system{Name: "History/Room", Temp: 30}, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: float64(i), Power: 50, Setpoint: float64Ptr(30)}), start.Add(time.Duration(i)*10*time.Second))
+	}
+
+	points, err := s.query("History/Room", "1", fieldTemperature, start, start.Add(2*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 2 || points[0].Value != 2.5 || points[1].Value != 8.5 || !points[1].Timestamp.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected averages of 2.5 and 8.5 a minute apart, got %+v", points)
+	}
+
+	if points, _ := s.query("History/Room", "", fieldInternalTemperature, start, start.Add(50*time.Second), time.Minute); len(points) != 1 || points[0].Value != 30 {
+		t.Errorf("expected the internal temperature, got %+v", points)
+	}
+
+	if points, err := s.query("Nowhere", "1", fieldTemperature, start, start.Add(time.Minute), time.Minute); err != nil || len(points) != 0 {
+		t.Errorf("expected nothing for an unknown system, got %+v (%v)", points, err)
+	}
+
+	// two hours later, the ring has wrapped all the way around
+	later := start.Add(2 * time.Hour)
+	s.polled(nil, newTestInfo(system{Name: "History/Room", Temp: 30}, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 100, Power: 50, Setpoint: float64Ptr(30)}), later)
+
+	if points, _ := s.query("History/Room", "1", fieldTemperature, start, later, time.Hour); len(points) != 1 || points[0].Value != 100 {
+		t.Errorf("expected only the newest reading after wrapping, got %+v", points)
+	}
+}
+
+func TestHistoryResolutionChange(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1700000000, 0)
+
+	s, _ := newHistoryStore(dir, time.Hour, 10*time.Second)
+	s.polled(nil, newTestInfo(system{Name: "History/Room", Temp: 30}, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 20, Power: 50, Setpoint: float64Ptr(30)}), start)
+
+	if points, _ := s.query("History/Room", "1", fieldTemperature, start, start.Add(time.Minute), time.Minute); len(points) != 1 {
+		t.Fatalf("expected one point, got %+v", points)
+	}
+
+	// the same files are picked up again after a restart
+	s, _ = newHistoryStore(dir, time.Hour, 10*time.Second)
+
+	if points, _ := s.query("History/Room", "1", fieldTemperature, start, start.Add(time.Minute), time.Minute); len(points) != 1 {
+		t.Errorf("expected the point to survive a restart, got %+v", points)
+	}
+
+	// but not if they were created with a different resolution
+	s, _ = newHistoryStore(dir, time.Hour, time.Minute)
+
+	if points, _ := s.query("History/Room", "1", fieldTemperature, start, start.Add(time.Minute), time.Minute); len(points) != 0 {
+		t.Errorf("expected the history to start over, got %+v", points)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	s, _ := newHistoryStore(t.TempDir(), time.Hour, 10*time.Second)
+
+	now := time.Now()
+	s.polled(nil, newTestInfo(system{Name: "History/Room", Temp: 30}, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 25, Power: 50, Setpoint: float64Ptr(30)}), now)
+
+	tests := []struct {
+		query  string
+		status int
+		points int
+	}{
+		{"system=History%2FRoom&output=1&field=temperature", http.StatusOK, 1},
+		{"system=History%2FRoom&output=1&field=power&step=1m", http.StatusOK, 1},
+		{"system=History%2FRoom&field=internal_temperature", http.StatusOK, 1},
+		{fmt.Sprintf("system=History%%2FRoom&output=1&field=setpoint&from=%d&to=%d", now.Add(-time.Minute).Unix(), now.Add(time.Minute).Unix()), http.StatusOK, 1},
+		{"output=1&field=temperature", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&field=temperature", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=internal_temperature", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=pressure", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=temperature&from=yesterday", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=temperature&from=0&to=1000000&step=10s", http.StatusBadRequest, 0},
+		// readings are kept in slots counted from the epoch, so there's nothing before it
+		{"system=History%2FRoom&output=1&field=temperature&from=-3600&to=0", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=temperature&to=-60", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=temperature&from=1969-12-31T23:00:00Z&to=1970-01-01T00:30:00Z", http.StatusBadRequest, 0},
+		// which also goes for the default from, an hour before to
+		{"system=History%2FRoom&output=1&field=temperature&to=60", http.StatusBadRequest, 0},
+		{"system=History%2FRoom&output=1&field=temperature&from=0&to=3600", http.StatusOK, 0},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handler()(rec, httptest.NewRequest(http.MethodGet, historyQueryPath+"?"+tt.query, http.NoBody))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d (%s)", tt.query, tt.status, rec.Code, rec.Body)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var body historyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Points) != tt.points {
+			t.Errorf("%s: expected %d points, got %+v", tt.query, tt.points, body.Points)
+		}
+	}
+}
+
+func TestHistoryStaysInItsDirectory(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "history")
+	start := time.Unix(1700000000, 0)
+
+	s, err := newHistoryStore(dir, time.Hour, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"..", ".", "../..", "..\\..", ".hidden", "%2E%2E"} {
+		s.polled(nil, newTestInfo(system{Name: name, Temp: 30}, output{mode: modeHeating, unit: unitCelsius, ProbeTemp: 20, Power: 50, Setpoint: float64Ptr(30)}), start)
+
+		if points, _ := s.query(name, "1", fieldTemperature, start, start.Add(time.Minute), time.Minute); len(points) != 1 {
+			t.Errorf("%q: expected its own history, got %+v", name, points)
+		}
+	}
+
+	// nothing was written next to the history directory
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "history" {
+		t.Errorf("expected only the history directory, got %v", entries)
+	}
+
+	if _, err := s.path("..", "", fieldInternalTemperature); err != nil {
+		t.Errorf("expected .. to be escaped, got %v", err)
+	}
+}
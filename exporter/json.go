@@ -1,8 +1,11 @@
 package exporter
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -12,6 +15,17 @@ import (
 const (
 	safetyRelayOff = "OFF (NORMAL OPERATION)"
 	rampingOff     = "Not In Session"
+
+	// no SpyderWeb has anywhere near this many outputs, so a larger numberofoutputs is garbage
+	maxOutputCount = 32
+
+	alarmDirectionHigh = "high"
+	alarmDirectionLow  = "low"
+
+	setpointCurrent   = "current"
+	setpointDay       = "day"
+	setpointNight     = "night"
+	setpointRampStart = "ramp_start"
 )
 
 type info struct {
@@ -19,6 +33,14 @@ type info struct {
 	outputs *[]output
 }
 
+// newInfo returns an empty [exporter.info], ready to be unmarshaled into
+func newInfo() *info {
+	return &info{
+		system:  &system{},
+		outputs: &[]output{},
+	}
+}
+
 // information about the herpstat system itself
 type system struct {
 	Name        string          `json:"nickname"`
@@ -29,6 +51,9 @@ type system struct {
 	OutputCount float64         `json:"numberofoutputs"`
 	PowerResets float64         `json:"powerresets"`
 	Temp        float64         `json:"internaltemp"`
+
+	unit    temperatureUnit
+	invalid fieldSet
 }
 
 // information about an individual herpstat output
@@ -47,12 +72,26 @@ type output struct {
 	AlarmLow      float64 `json:"lowalarm,omitempty"`
 	RampEnd       float64 `json:"endoframpsetting,omitempty"`
 	ErrorCode     float64 `json:"errorcode,omitempty"`
+
+	// setpoints are pointers since 0 is a perfectly good setting. Unlike everything above, these keys haven't been
+	// confirmed against a real /RAWSTATUS response, so they're only used if a device happens to report them. A
+	// device that doesn't simply has no setpoint, control error or schedule metrics.
+	Setpoint      *float64 `json:"currentsetting,omitempty"`
+	DaySetpoint   *float64 `json:"daytimesetting,omitempty"`
+	NightSetpoint *float64 `json:"nighttimesetting,omitempty"`
+	RampStart     *float64 `json:"startoframpsetting,omitempty"`
+
+	mode    outputMode
+	unit    temperatureUnit
+	invalid fieldSet
+	fault   string
 }
 
 // UnmarshalJSON implements a custom JSON unmarshaler for our /RAWSTATUS data, which comes back in a format that's
 // difficult to work with without making an arbitrary number of additional numbered [output] structs. Everything
 // in "system" goes into [herpstat.exporter.info.system] and all of the numbered outputs (output1, output2, ...)
-// are added to an array in [herpstat.exporter.info.outputs] in their numbered order.
+// are added to an array in [herpstat.exporter.info.outputs] in their numbered order. Anything else is ignored, as
+// are outputs numbered outside of the device's numberofoutputs.
 //
 //	{
 //	  "system":{},
@@ -72,12 +111,26 @@ func (info *info) UnmarshalJSON(data []byte) error {
 
 	level.Debug(logger).Log("msg", "unmarshaling system data")
 
+	// null would leave us without a system at all, and everything else depends on it
+	if !isJSONObject(mapped["system"]) {
+		level.Error(logger).Log("msg", "system data is missing or isn't an object")
+		return errors.New("system data is missing or isn't an object")
+	}
+
 	if err := json.Unmarshal(mapped["system"], &info.system); err != nil {
 		level.Error(logger).Log("msg", "unable to unmarshal system data")
 		return err
 	}
 
-	level.Debug(logger).Log(info.system)
+	info.system.markMissingReadings(mapped["system"])
+
+	level.Debug(logger).Log("msg", "unmarshaled system data", "name", info.system.Name, "outputs", info.system.OutputCount)
+
+	// the outputs are allocated up front, so a garbled count mustn't be trusted
+	if count := info.system.OutputCount; math.IsNaN(count) || count < 0 || count > maxOutputCount {
+		level.Error(logger).Log("msg", "invalid number of outputs", "outputs", count)
+		return fmt.Errorf("invalid number of outputs: %v", count)
+	}
 
 	*info.outputs = make([]output, int(info.system.OutputCount))
 
@@ -86,31 +139,156 @@ func (info *info) UnmarshalJSON(data []byte) error {
 			continue
 		}
 
-		level.Debug(logger).Log("msg", "unmarshaling output data")
-
+		// newer firmware might add other sections that we don't know about yet
 		id, err := strconv.Atoi(strings.TrimPrefix(key, "output"))
-		if err != nil {
-			return fmt.Errorf("%s doesn't look like 'output#' where # is a number: %s", key, err.Error())
+		if !strings.HasPrefix(key, "output") || err != nil {
+			level.Debug(logger).Log("msg", "ignoring unknown section", "key", key)
+			continue
 		}
 
-		if id > int(info.system.OutputCount) {
-			return fmt.Errorf("output id %d is > than # of available outputs (%d)", id, int(info.system.OutputCount))
+		if id < 1 || id > int(info.system.OutputCount) {
+			level.Warn(logger).Log("msg", fmt.Sprintf("ignoring %s, since the device says that it only has %d outputs", key, int(info.system.OutputCount)))
+			continue
 		}
 
+		if !isJSONObject(value) {
+			level.Warn(logger).Log("msg", fmt.Sprintf("ignoring %s, since it isn't an object", key))
+			continue
+		}
+
+		level.Debug(logger).Log("msg", "unmarshaling output data")
+
 		err = json.Unmarshal(value, &(*info.outputs)[id-1])
 		if err != nil {
 			level.Error(logger).Log("msg", fmt.Sprintf("unable to unmarshal output data for %s", key))
 			return err
 		}
 
+		(*info.outputs)[id-1].markMissingReadings(value)
 		(*info.outputs)[id-1].ID = fmt.Sprintf("%d", id)
+		(*info.outputs)[id-1].mode = parseOutputMode((*info.outputs)[id-1].Mode)
+		(*info.outputs)[id-1].fault = (*info.outputs)[id-1].detectProbeFault()
+
+		level.Debug(logger).Log("msg", "unmarshaled output data", "output", id, "name", (*info.outputs)[id-1].Name, "mode", (*info.outputs)[id-1].mode)
+	}
 
-		level.Debug(logger).Log(&(*info.outputs)[id-1])
+	// some firmware only lists the outputs that are in use, even though numberofoutputs counts all of them
+	outputs := (*info.outputs)[:0]
+
+	for i := range *info.outputs {
+		if (*info.outputs)[i].ID != "" {
+			outputs = append(outputs, (*info.outputs)[i])
+		}
 	}
 
+	*info.outputs = outputs
+
 	return nil
 }
 
+// isJSONObject checks whether a raw value is a JSON object, rather than null, a number, etc
+func isJSONObject(data json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// markMissingReadings makes an internal temperature that's null or missing altogether into NaN, so that it's
+// rejected by validation rather than being mistaken for a reading of 0
+func (s *system) markMissingReadings(data json.RawMessage) {
+	fields := nullFields(data)
+
+	if null, present := fields["internaltemp"]; null || !present {
+		s.Temp = math.NaN()
+	}
+}
+
+// markMissingReadings makes any null readings into NaN, so that they're rejected by validation rather than being
+// mistaken for readings of 0. Readings that are missing altogether are left alone, since they're only sent for modes
+// that use them.
+func (o *output) markMissingReadings(data json.RawMessage) {
+	fields := nullFields(data)
+
+	for key, reading := range map[string]*float64{
+		"probereadingTEMP": &o.ProbeTemp,
+		"probereadingRH":   &o.ProbeHumidity,
+		"poweroutput":      &o.Power,
+	} {
+		if fields[key] {
+			*reading = math.NaN()
+		}
+	}
+}
+
+// nullFields returns every field in a JSON object, and whether it's null. [exporter.repairRawstatus] turns NaN-like
+// readings into null.
+func nullFields(data json.RawMessage) map[string]bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	null := map[string]bool{}
+	for key, value := range fields {
+		null[key] = string(value) == "null"
+	}
+
+	return null
+}
+
+// measuresHumidity checks whether an output is controlling humidity rather than temperature, based on its mode
+func (o *output) measuresHumidity() bool {
+	return o.mode == modeHumidity
+}
+
+// probeReading returns whichever probe reading this output is controlling, as long as it looks sane
+func (o *output) probeReading() (float64, bool) {
+	if o.measuresHumidity() {
+		return o.ProbeHumidity, o.invalid.valid(fieldHumidity)
+	}
+
+	return o.ProbeTemp, o.invalid.valid(fieldTemperature)
+}
+
+// alarmActive checks whether the probe reading is past the output's own high or low alarm setting. An output
+// without its alarm enabled is never in alarm.
+func (o *output) alarmActive(reading float64) (high, low float64) {
+	if o.AlarmEnabled != 1 {
+		return 0, 0
+	}
+
+	if reading > o.AlarmHigh {
+		high = 1
+	}
+
+	if reading < o.AlarmLow {
+		low = 1
+	}
+
+	return high, low
+}
+
+// alarmMargin is how far the probe reading is from the nearest of the output's alarm settings, in Celsius for
+// temperature outputs. It goes negative once the output is in alarm.
+func (o *output) alarmMargin(reading float64) float64 {
+	return o.settingDelta(math.Min(o.AlarmHigh-reading, reading-o.AlarmLow))
+}
+
+// setpoints returns every setpoint that the device gave us for this output, keyed by the "setting" label
+func (o *output) setpoints() map[string]*float64 {
+	return map[string]*float64{
+		setpointCurrent:   o.Setpoint,
+		setpointDay:       o.DaySetpoint,
+		setpointNight:     o.NightSetpoint,
+		setpointRampStart: o.RampStart,
+	}
+}
+
+// controlError is how far the probe reading is from the current setpoint, in Celsius for temperature outputs. It's
+// positive when the reading is above the setpoint.
+func (o *output) controlError(reading float64) float64 {
+	return o.settingDelta(reading - *o.Setpoint)
+}
+
 func (o *output) ramping() float64 {
 	if o.Ramping == rampingOff {
 		return 0
@@ -127,14 +305,32 @@ func (s *system) safetyrelay() float64 {
 	return 1
 }
 
+// firmware returns the firmware version, which some firmware sends as a number and some sends as a string
+func (s *system) firmware() string {
+	var version string
+	if err := json.Unmarshal(s.Firmware, &version); err == nil {
+		return version
+	}
+
+	return string(s.Firmware)
+}
+
 func (s *system) infoLabelValues() []string {
-	return []string{s.Name, s.IP, s.Mac, string(s.Firmware), fmt.Sprintf("%.0f", s.OutputCount)}
+	return []string{s.Name, s.IP, s.Mac, s.firmware(), fmt.Sprintf("%.0f", s.OutputCount)}
 }
 
 func (s *system) safetyRelayLabelValues() []string {
 	return []string{s.Name, s.SafetyRelay}
 }
 
+func (s *system) stateLabelValues(state string) []string {
+	return []string{s.Name, state}
+}
+
+func (s *system) unitLabelValues() []string {
+	return []string{s.Name, string(s.unit)}
+}
+
 func (s *system) labelValues() []string {
 	return []string{s.Name}
 }
@@ -147,6 +343,18 @@ func (o *output) labelValues(system *string) []string {
 	return []string{*system, o.ID}
 }
 
+func (o *output) alarmLabelValues(system *string, direction string) []string {
+	return []string{*system, o.ID, direction}
+}
+
+func (o *output) setpointLabelValues(system *string, setting string) []string {
+	return []string{*system, o.ID, setting}
+}
+
+func (o *output) modeLabelValues(system *string, mode outputMode) []string {
+	return []string{*system, o.ID, string(mode)}
+}
+
 func (o *output) errorLabelValues(system *string) []string {
 	return []string{*system, o.ID, o.ErrorDesc}
 }
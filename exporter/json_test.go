@@ -0,0 +1,110 @@
+package exporter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalSetpoints(t *testing.T) {
+	polled := newInfo()
+	if err := json.Unmarshal(readFixture(t, "rawstatus_setpoints.json"), polled); err != nil {
+		t.Fatalf("unable to unmarshal fixture: %s", err)
+	}
+
+	outputs := *polled.outputs
+	if len(outputs) != 3 {
+		t.Fatalf("expected 3 outputs, got %d", len(outputs))
+	}
+
+	tests := []struct {
+		name  string
+		value *float64
+		want  *float64
+	}{
+		{"output1 current", outputs[0].Setpoint, float64Ptr(95)},
+		{"output1 day", outputs[0].DaySetpoint, float64Ptr(95)},
+		{"output1 night", outputs[0].NightSetpoint, float64Ptr(77)},
+		{"output1 ramp start", outputs[0].RampStart, float64Ptr(86)},
+		{"output2 current", outputs[1].Setpoint, float64Ptr(65)},
+		{"output2 day", outputs[1].DaySetpoint, nil},
+		{"output3 current", outputs[2].Setpoint, nil},
+	}
+
+	for _, tt := range tests {
+		switch {
+		case tt.want == nil && tt.value != nil:
+			t.Errorf("%s: expected no setpoint, got %v", tt.name, *tt.value)
+		case tt.want != nil && tt.value == nil:
+			t.Errorf("%s: expected %v, got no setpoint", tt.name, *tt.want)
+		case tt.want != nil && *tt.want != *tt.value:
+			t.Errorf("%s: expected %v, got %v", tt.name, *tt.want, *tt.value)
+		}
+	}
+}
+
+func TestUnmarshalUnexpectedSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		outputs []string
+	}{
+		{
+			name:    "unknown section",
+			data:    `{"system":{"numberofoutputs":2},"network":{"ssid":"reptiles"},"output1":{"outputmode":"Timer"}}`,
+			outputs: []string{"1"},
+		},
+		{
+			name:    "output0",
+			data:    `{"system":{"numberofoutputs":2},"output0":{"outputmode":"Timer"},"output2":{"outputmode":"Timer"}}`,
+			outputs: []string{"2"},
+		},
+		{
+			name:    "output past numberofoutputs",
+			data:    `{"system":{"numberofoutputs":2},"output1":{"outputmode":"Timer"},"output3":{"outputmode":"Timer"}}`,
+			outputs: []string{"1"},
+		},
+		{
+			name:    "output without a number",
+			data:    `{"system":{"numberofoutputs":2},"outputs":{},"output-1":{},"output1":{"outputmode":"Timer"}}`,
+			outputs: []string{"1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polled := newInfo()
+			if err := json.Unmarshal([]byte(tt.data), polled); err != nil {
+				t.Fatalf("unable to unmarshal: %s", err)
+			}
+
+			ids := []string{}
+			for _, o := range *polled.outputs {
+				ids = append(ids, o.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.outputs) {
+				t.Errorf("expected outputs %v, got %v", tt.outputs, ids)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInvalidOutputCount(t *testing.T) {
+	for _, fixture := range []string{"rawstatus_negative_outputs.json", "rawstatus_too_many_outputs.json"} {
+		if err := json.Unmarshal(readFixture(t, fixture), newInfo()); err == nil {
+			t.Errorf("%s: expected the number of outputs to be rejected", fixture)
+		}
+	}
+
+	// a NaN count is repaired to null, which leaves it at 0 rather than failing
+	repaired, _, err := repairRawstatus([]byte(`{"system":{"numberofoutputs":NaN},"output1":{"outputmode":"Timer"}}`))
+	if err != nil {
+		t.Fatalf("unable to repair: %s", err)
+	}
+
+	polled := newInfo()
+	if err := json.Unmarshal(repaired, polled); err != nil || len(*polled.outputs) != 0 {
+		t.Errorf("expected no outputs for a NaN count, got %d (%v)", len(*polled.outputs), err)
+	}
+}
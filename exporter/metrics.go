@@ -9,114 +9,242 @@ const namespace = "herpstat"
 var (
 	systemLabelNames            = []string{"system"}
 	systemSafetyRelayLabelNames = []string{"system", "relay"}
+	systemUnitLabelNames        = []string{"system", "unit"}
+	systemStateLabelNames       = []string{"system", "state"}
 	systemInfoLabelNames        = []string{"system", "ip", "mac", "firmware", "outputs"}
 
-	outputLabelNames      = []string{"system", "output"}
-	outputInfoLabelNames  = []string{"system", "output", "name", "mode"}
-	outputErrorLabelNames = []string{"system", "output", "error"}
+	outputLabelNames            = []string{"system", "output"}
+	outputInfoLabelNames        = []string{"system", "output", "name", "mode"}
+	outputErrorLabelNames       = []string{"system", "output", "error"}
+	outputAlarmLabelNames       = []string{"system", "output", "direction"}
+	outputModeLabelNames        = []string{"system", "output", "mode"}
+	outputSetpointLabelNames    = []string{"system", "output", "setting"}
+	outputWindowLabelNames      = []string{"system", "output", "window"}
+	outputPhaseLabelNames       = []string{"system", "output", "phase"}
+	outputPhaseWindowLabelNames = []string{"system", "output", "phase", "window"}
+	outputStateLabelNames       = []string{"system", "output", "state"}
+	outputResultLabelNames      = []string{"system", "output", "result"}
+	outputProbeFaultLabelNames  = []string{"system", "output", "reason"}
+	outputErrorActiveLabelNames = []string{"system", "output", "code", "reason", "severity"}
+
+	healthLabelNames = []string{"target", "result", "kind", "field", "reason"}
 )
 
 type metrics struct {
-	info                *prometheus.Desc
-	temp                *prometheus.Desc
-	resets              *prometheus.Desc
-	safetyRelay         *prometheus.Desc
-	outputInfo          *prometheus.Desc
-	outputPower         *prometheus.Desc
-	outputPowerLimit    *prometheus.Desc
-	outputProbeTemp     *prometheus.Desc
-	outputProbeHumidity *prometheus.Desc
-	outputAlarmEnabled  *prometheus.Desc
-	outputAlarmHigh     *prometheus.Desc
-	outputAlarmLow      *prometheus.Desc
-	outputRamping       *prometheus.Desc
-	outputRampEnd       *prometheus.Desc
-	outputError         *prometheus.Desc
+	info                 *prometheus.Desc
+	temp                 *prometheus.Desc
+	tempUnit             *prometheus.Desc
+	resets               *prometheus.Desc
+	lastReset            *prometheus.Desc
+	safetyRelay          *prometheus.Desc
+	safetyRelayState     *prometheus.Desc
+	safetyRelayTrips     *prometheus.Desc
+	outputInfo           *prometheus.Desc
+	outputMode           *prometheus.Desc
+	outputPower          *prometheus.Desc
+	outputPowerLimit     *prometheus.Desc
+	outputProbeTemp      *prometheus.Desc
+	outputProbeHumidity  *prometheus.Desc
+	outputProbeConnected *prometheus.Desc
+	outputProbeFault     *prometheus.Desc
+	outputAlarmEnabled   *prometheus.Desc
+	outputAlarmHigh      *prometheus.Desc
+	outputAlarmLow       *prometheus.Desc
+	outputAlarmActive    *prometheus.Desc
+	outputAlarmMargin    *prometheus.Desc
+	outputRamping        *prometheus.Desc
+	outputRampEnd        *prometheus.Desc
+	outputRampState      *prometheus.Desc
+	outputRampProgress   *prometheus.Desc
+	outputRampStarted    *prometheus.Desc
+	outputRampETA        *prometheus.Desc
+	outputRampSessions   *prometheus.Desc
+	outputRampDuration   *prometheus.Desc
+	outputEnergy         *prometheus.Desc
+	outputDutyCycle      *prometheus.Desc
+	outputSchedulePhase  *prometheus.Desc
+	outputCompliance     *prometheus.Desc
+	outputSetpoint       *prometheus.Desc
+	outputControlError   *prometheus.Desc
+	outputError          *prometheus.Desc
+	outputErrorActive    *prometheus.Desc
+	lastPoll             *prometheus.Desc
+	pollAge              *prometheus.Desc
 }
 
 // newOutputMetric is a convenience wrapper for [exporter.newMetric] that creates a new Prometheus desecriptor for
 // an [exporter.output] metric with a given name, description, and optional labels.
 // [exporter.outputLabelNames] is used as a default if no labels are given.
-func newOutputMetric(name, description string, labels ...string) *prometheus.Desc {
+func newOutputMetric(constLabels prometheus.Labels, name, description string, labels ...string) *prometheus.Desc {
 	if labels == nil {
 		labels = outputLabelNames
 	}
 
-	return newMetric("output", name, description, labels...)
+	return newMetric(constLabels, "output", name, description, labels...)
 }
 
 // newSystemMetric is a convenience wrapper for [exporter.newMetric] that creates a new Prometheus desecriptor for
 // an [exporter.system] metric with a given name, description, and optional labels.
 // [exporter.systemLabelNames] is used as a default if no labels are given.
-func newSystemMetric(name, description string, labels ...string) *prometheus.Desc {
+func newSystemMetric(constLabels prometheus.Labels, name, description string, labels ...string) *prometheus.Desc {
 	if labels == nil {
 		labels = systemLabelNames
 	}
 
-	return newMetric("system", name, description, labels...)
+	return newMetric(constLabels, "system", name, description, labels...)
 }
 
 // newMetric is a convenience wrapper for [prometheus.NewDesc] to create a new [prometheus.Desc] descriptor
-// with a given susbsytem, name, description, and labels.
-func newMetric(subsystem, name, description string, labels ...string) *prometheus.Desc {
+// with a given susbsytem, name, description, and labels. constLabels are the extra static labels from a
+// device's [exporter.deviceConfig.Labels].
+func newMetric(constLabels prometheus.Labels, subsystem, name, description string, labels ...string) *prometheus.Desc {
 	return prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, name),
 		description,
 		labels,
-		nil,
+		constLabels,
 	)
 }
 
-func newMetrics() *metrics {
+// newMetrics creates all of our metric descriptors, adding the given static labels to each of them
+func newMetrics(constLabels map[string]string) *metrics {
+	l := prometheus.Labels(constLabels)
+
 	return &metrics{
-		info: newSystemMetric("info",
+		info: newSystemMetric(l, "info",
 			"Information about the Herpstat system itself.",
 			systemInfoLabelNames...,
 		),
-		temp: newSystemMetric("temperature",
+		temp: newSystemMetric(l, "temperature_celsius",
 			"Current internal temperature.",
 		),
-		resets: newSystemMetric("reset_total",
-			"Number of times Herpstat has reset",
+		tempUnit: newSystemMetric(l, "temperature_unit",
+			"Which unit the Herpstat reports temperatures in. Everything is exported in Celsius.",
+			systemUnitLabelNames...,
+		),
+		resets: newSystemMetric(l, "reset_total",
+			"Number of times Herpstat has reset, according to the Herpstat itself.",
+		),
+		lastReset: newSystemMetric(l, "last_reset_timestamp_seconds",
+			"When the exporter noticed that the Herpstat had reset, in seconds since the epoch.",
 		),
-		safetyRelay: newSystemMetric("safetyrelay",
+		safetyRelay: newSystemMetric(l, "safetyrelay",
 			"Safety relay status.",
 			systemSafetyRelayLabelNames...,
 		),
-		outputInfo: newOutputMetric("info",
+		safetyRelayState: newSystemMetric(l, "safetyrelay_state",
+			"Parsed safety relay status. Exactly one state is 1 at a time.",
+			systemStateLabelNames...,
+		),
+		safetyRelayTrips: newSystemMetric(l, "safetyrelay_trips_total",
+			"Number of times the safety relay was seen tripping in between polls.",
+		),
+		outputInfo: newOutputMetric(l, "info",
 			"Metadata about the output.",
 			outputInfoLabelNames...,
 		),
-		outputPower: newOutputMetric("power",
+		outputMode: newOutputMetric(l, "mode",
+			"What this output is being used for. Exactly one mode is 1 at a time.",
+			outputModeLabelNames...,
+		),
+		outputPower: newOutputMetric(l, "power",
 			"Current output power level.",
 		),
-		outputPowerLimit: newOutputMetric("power_limit",
+		outputPowerLimit: newOutputMetric(l, "power_limit",
 			"Current output power limit.",
 		),
-		outputProbeTemp: newOutputMetric("probe_temperature",
+		outputProbeTemp: newOutputMetric(l, "probe_temperature_celsius",
 			"Current probe temperature.",
 		),
-		outputProbeHumidity: newOutputMetric("probe_humidity",
+		outputProbeHumidity: newOutputMetric(l, "probe_humidity",
 			"Current probe humidity level.",
 		),
-		outputAlarmEnabled: newOutputMetric("alarm_enabled",
+		outputProbeConnected: newOutputMetric(l, "probe_connected",
+			"Is the output's probe plugged in?",
+		),
+		outputProbeFault: newOutputMetric(l, "probe_fault",
+			"Why the output's probe is faulted. At most one reason is 1 at a time.",
+			outputProbeFaultLabelNames...,
+		),
+		outputAlarmEnabled: newOutputMetric(l, "alarm_enabled",
 			"Output alarm enabled.",
 		),
-		outputAlarmHigh: newOutputMetric("alarm_high",
-			"Output Alarm High value.",
+		outputAlarmHigh: newOutputMetric(l, "alarm_high",
+			"Output Alarm High value. Celsius for temperature outputs and percent for humidity outputs.",
+		),
+		outputAlarmLow: newOutputMetric(l, "alarm_low",
+			"Output Alarm Low value. Celsius for temperature outputs and percent for humidity outputs.",
 		),
-		outputAlarmLow: newOutputMetric("alarm_low",
-			"Output Alarm Low value.",
+		outputAlarmActive: newOutputMetric(l, "alarm_active",
+			"Is the probe reading past the output's own high or low alarm setting?",
+			outputAlarmLabelNames...,
 		),
-		outputRamping: newOutputMetric("ramping",
+		outputAlarmMargin: newOutputMetric(l, "alarm_margin",
+			"Distance from the probe reading to the nearest alarm setting. Celsius for temperature outputs and percent for humidity outputs. Negative when in alarm.",
+		),
+		outputRamping: newOutputMetric(l, "ramping",
 			"Is this output currently ramping?",
 		),
-		outputRampEnd: newOutputMetric("ramp_end",
-			"Ramp end value.",
+		outputRampEnd: newOutputMetric(l, "ramp_end",
+			"Ramp end value. Celsius for temperature outputs and percent for humidity outputs.",
+		),
+		outputRampState: newOutputMetric(l, "ramp_state",
+			"Parsed ramping status. Exactly one state is 1 at a time.",
+			outputStateLabelNames...,
+		),
+		outputRampProgress: newOutputMetric(l, "ramp_progress_ratio",
+			"How far the current setpoint has moved from the start of the ramp to its end, from 0 to 1.",
 		),
-		outputError: newOutputMetric("error",
+		outputRampStarted: newOutputMetric(l, "ramp_session_start_timestamp_seconds",
+			"When the exporter first saw the current ramp session, in seconds since the epoch.",
+		),
+		outputRampETA: newOutputMetric(l, "ramp_eta_seconds",
+			"Estimated time until the current ramp session reaches its end, based on its progress so far.",
+		),
+		outputRampSessions: newOutputMetric(l, "ramp_sessions_total",
+			"Number of ramp sessions that the exporter has seen end, by whether they reached the end of the ramp.",
+			outputResultLabelNames...,
+		),
+		outputRampDuration: newOutputMetric(l, "ramp_last_session_duration_seconds",
+			"How long the last ramp session that the exporter saw end took.",
+		),
+		outputEnergy: newOutputMetric(l, "energy_joules_total",
+			"Energy used by the output's load, based on its configured wattage and power level.",
+		),
+		outputDutyCycle: newOutputMetric(l, "duty_cycle_ratio",
+			"How much of the time within the window that the output was on, weighted by its power level.",
+			outputWindowLabelNames...,
+		),
+		outputSchedulePhase: newOutputMetric(l, "schedule_phase",
+			"Which phase of its day/night schedule the output is in.",
+			outputPhaseLabelNames...,
+		),
+		outputCompliance: newOutputMetric(l, "schedule_compliance_ratio",
+			"How much of the time within the window that the probe reading was within tolerance of the phase's setpoint.",
+			outputPhaseWindowLabelNames...,
+		),
+		outputSetpoint: newOutputMetric(l, "setpoint",
+			"Output target setting. Celsius for temperature outputs and percent for humidity outputs.",
+			outputSetpointLabelNames...,
+		),
+		outputControlError: newOutputMetric(l, "control_error",
+			"Difference between the probe reading and the current setpoint. Celsius for temperature outputs and percent for humidity outputs.",
+		),
+		outputError: newOutputMetric(l, "error",
 			"Error Code.",
 			outputErrorLabelNames...,
 		),
+		outputErrorActive: newOutputMetric(l, "error_active",
+			"Which of the known error codes this output is reporting. Exactly one is 1 at a time.",
+			outputErrorActiveLabelNames...,
+		),
+		lastPoll: newMetric(l, "", "last_poll_timestamp_seconds",
+			"When the current data was polled from the Herpstat, in seconds since the epoch.",
+			systemLabelNames...,
+		),
+		pollAge: newMetric(l, "", "poll_age_seconds",
+			"How long ago the current data was polled from the Herpstat.",
+			systemLabelNames...,
+		),
 	}
 }
@@ -0,0 +1,68 @@
+package exporter
+
+import "strings"
+
+// outputMode is a parsed version of an output's free-form "outputmode" field, which decides which of its metrics
+// actually make sense. eg: a lighting output doesn't have a probe, so there's no point in exporting its readings.
+type outputMode string
+
+const (
+	modeHeating  outputMode = "heating"
+	modeCooling  outputMode = "cooling"
+	modeHumidity outputMode = "humidity"
+	modeTimer    outputMode = "timer"
+	modeLighting outputMode = "lighting"
+	modeOff      outputMode = "off"
+	modeUnknown  outputMode = "unknown"
+)
+
+// outputModes is every mode in the order they're exported in the herpstat_output_mode state set
+var outputModes = []outputMode{
+	modeHeating,
+	modeCooling,
+	modeHumidity,
+	modeTimer,
+	modeLighting,
+	modeOff,
+	modeUnknown,
+}
+
+// parseOutputMode turns the device's description of an output's mode (eg: "Proportional Heating") into an
+// [exporter.outputMode]. Order matters here, since "Dehumidifying" shouldn't count as heating or cooling.
+func parseOutputMode(raw string) outputMode {
+	mode := strings.ToLower(raw)
+
+	switch {
+	case strings.Contains(mode, "humid"):
+		return modeHumidity
+	case strings.Contains(mode, "cool"):
+		return modeCooling
+	case strings.Contains(mode, "heat"), strings.Contains(mode, "thermostat"):
+		return modeHeating
+	case strings.Contains(mode, "timer"):
+		return modeTimer
+	case strings.Contains(mode, "light"), strings.Contains(mode, "dimm"):
+		return modeLighting
+	case strings.Contains(mode, "off"), strings.Contains(mode, "disabled"):
+		return modeOff
+	default:
+		return modeUnknown
+	}
+}
+
+// hasTemperatureProbe checks whether outputs in this mode are controlled by a temperature probe. Unknown modes are
+// assumed to have every kind of probe so that nothing gets hidden.
+func (m outputMode) hasTemperatureProbe() bool {
+	return m == modeHeating || m == modeCooling || m == modeUnknown
+}
+
+// hasHumidityProbe checks whether outputs in this mode are controlled by a humidity probe
+func (m outputMode) hasHumidityProbe() bool {
+	return m == modeHumidity || m == modeUnknown
+}
+
+// hasProbe checks whether outputs in this mode are controlled by any kind of probe, which means that their alarm
+// and ramp settings mean something
+func (m outputMode) hasProbe() bool {
+	return m.hasTemperatureProbe() || m.hasHumidityProbe()
+}
@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseOutputMode(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want outputMode
+	}{
+		{"Proportional Heating", modeHeating},
+		{"On/Off Heating", modeHeating},
+		{"Thermostat", modeHeating},
+		{"Proportional Cooling", modeCooling},
+		{"Humidifying", modeHumidity},
+		{"Dehumidifying", modeHumidity},
+		{"Timer", modeTimer},
+		{"Lighting", modeLighting},
+		{"Dimming", modeLighting},
+		{"Output Off", modeOff},
+		{"Disabled", modeOff},
+		{"PROPORTIONAL HEATING", modeHeating},
+		{"Pulse Width", modeUnknown},
+		{"", modeUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := parseOutputMode(tt.raw); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.raw, tt.want, got)
+		}
+	}
+}
+
+func TestCollectByOutputMode(t *testing.T) {
+	modes := []struct {
+		raw         string
+		temperature bool
+		humidity    bool
+		alarm       bool
+	}{
+		{"Proportional Heating", true, false, true},
+		{"Proportional Cooling", true, false, true},
+		{"Humidifying", false, true, true},
+		{"Timer", false, false, false},
+		{"Lighting", false, false, false},
+		{"Output Off", false, false, false},
+		// nothing is hidden from a mode that we don't recognise
+		{"Pulse Width", true, true, true},
+	}
+
+	outputs := make([]string, 0, len(modes))
+	for i, m := range modes {
+		outputs = append(outputs, fmt.Sprintf(`"output%d":{"outputmode":%q,"probereadingTEMP":86,"probereadingRH":60,"enablehighlowalarm":1,"highalarm":95,"lowalarm":80}`, i+1, m.raw))
+	}
+
+	e := newExporter(newDeviceConfig(fixtureAddress))
+	e.herpstat.client.Transport = fixtureTransport(fmt.Sprintf(`{"system":{"nickname":"Modes","numberofoutputs":%d,"internaltemp":75},%s}`, len(modes), strings.Join(outputs, ",")))
+
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll")
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+
+	// which metrics were exported for each output
+	exported := map[string]map[string]bool{}
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() != "output" {
+					continue
+				}
+
+				if exported[l.GetValue()] == nil {
+					exported[l.GetValue()] = map[string]bool{}
+				}
+
+				exported[l.GetValue()][family.GetName()] = true
+			}
+		}
+	}
+
+	for i, m := range modes {
+		got := exported[fmt.Sprint(i+1)]
+
+		expected := map[string]bool{
+			"herpstat_output_mode":                      true,
+			"herpstat_output_power_limit":               true,
+			"herpstat_output_probe_temperature_celsius": m.temperature,
+			"herpstat_output_probe_humidity":            m.humidity,
+			"herpstat_output_probe_connected":           m.alarm,
+			"herpstat_output_alarm_enabled":             m.alarm,
+			"herpstat_output_alarm_margin":              m.alarm,
+			"herpstat_output_ramping":                   m.alarm,
+		}
+
+		for name, want := range expected {
+			if got[name] != want {
+				t.Errorf("%s: expected %s to be exported: %v", m.raw, name, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	defaultMQTTClientID        = "herpstat_spyderweb_exporter"
+	defaultMQTTTopicPrefix     = "herpstat"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTTimeout         = 5 * time.Second
+
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+	mqttQoS     = 1
+
+	// how often each device's reachability is checked, so that it can be marked offline once its data goes stale
+	mqttAvailabilityInterval = 5 * time.Second
+)
+
+// errMQTTDisconnected is returned instead of waiting for a message to be sent while the broker can't be reached
+var errMQTTDisconnected = errors.New("not connected to the MQTT broker")
+
+// mqttUnsafeCharacters are replaced in anything that ends up in a topic or a Home Assistant ID
+var mqttUnsafeCharacters = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// mqttConfig describes the optional MQTT broker that readings are published to. Leaving out the broker disables it.
+//
+//	mqtt:
+//	  broker: tcp://localhost:1883
+//	  client_id: herpstat_spyderweb_exporter
+//	  username: herpstat
+//	  password: hunter2
+//	  topic_prefix: herpstat
+//	  discovery_prefix: homeassistant
+//	  disable_discovery: false
+//	  timeout: 5s
+type mqttConfig struct {
+	Broker           string        `yaml:"broker"`
+	ClientID         string        `yaml:"client_id,omitempty"`
+	Username         string        `yaml:"username,omitempty"`
+	Password         string        `yaml:"password,omitempty"`
+	TopicPrefix      string        `yaml:"topic_prefix,omitempty"`
+	DiscoveryPrefix  string        `yaml:"discovery_prefix,omitempty"`
+	DisableDiscovery bool          `yaml:"disable_discovery,omitempty"`
+	Timeout          time.Duration `yaml:"timeout,omitempty"`
+}
+
+// enabled checks whether a broker has been configured
+func (m *mqttConfig) enabled() bool {
+	return m.Broker != ""
+}
+
+// validate checks that the topics are usable and fills in the defaults
+func (m *mqttConfig) validate() error {
+	if !m.enabled() {
+		return nil
+	}
+
+	if m.ClientID == "" {
+		m.ClientID = defaultMQTTClientID
+	}
+
+	if m.TopicPrefix == "" {
+		m.TopicPrefix = defaultMQTTTopicPrefix
+	}
+
+	if m.DiscoveryPrefix == "" {
+		m.DiscoveryPrefix = defaultMQTTDiscoveryPrefix
+	}
+
+	if m.Timeout == 0 {
+		m.Timeout = defaultMQTTTimeout
+	}
+
+	for _, prefix := range []string{m.TopicPrefix, m.DiscoveryPrefix} {
+		if strings.ContainsAny(prefix, "+#") {
+			return fmt.Errorf("mqtt topic prefixes can't contain wildcards: %s", prefix)
+		}
+	}
+
+	return nil
+}
+
+// mqttConnection is the part of an MQTT client that [exporter.mqttPublisher] needs, so that it can be tested without
+// a broker
+type mqttConnection interface {
+	publish(topic string, retained bool, payload []byte) error
+	subscribe(topic string, handler func(payload []byte)) error
+}
+
+// mqttPublisher is an optional sink for people who would rather get readings in Home Assistant over MQTT than scrape
+// Prometheus. It listens for every successful poll (see [exporter.pollListener]) and publishes the device's system
+// and each of its outputs as JSON to their own retained state topics, using the same model as
+// [exporter.devicesPath]. Home Assistant discovery configs are published for each sensor whenever they change, and
+// again whenever Home Assistant restarts.
+//
+// Each device has its own availability topic, which goes offline once the device can't be polled or its data goes
+// stale. The exporter's own availability topic is the connection's last will, so everything goes offline if the
+// exporter does.
+//
+// Publishing waits for the broker to acknowledge each message, so polls only queue up the device's latest snapshot.
+// They're published by [exporter.mqttPublisher.run], and a snapshot that hasn't been published by the time that the
+// next one arrives is replaced by it.
+type mqttPublisher struct {
+	sync.Mutex
+
+	config     *mqttConfig
+	connection mqttConnection
+
+	// queue guards pending, separately from everything else, so that polls never wait on the broker
+	queue   sync.Mutex
+	pending map[string]*mqttSnapshot
+	wake    chan struct{}
+
+	// discovery holds every discovery config that has been published, by topic
+	discovery map[string]string
+
+	// online holds the last availability published for each device, by its MQTT ID
+	online map[string]bool
+}
+
+// mqttSnapshot is a poll that's waiting to be published
+type mqttSnapshot struct {
+	device *deviceConfig
+	info   *info
+}
+
+// haDevice is the "device" in a Home Assistant discovery config, which groups a device's sensors together
+type haDevice struct {
+	Identifiers  []string   `json:"identifiers"`
+	Connections  [][]string `json:"connections,omitempty"`
+	Name         string     `json:"name"`
+	Manufacturer string     `json:"manufacturer"`
+	Model        string     `json:"model"`
+	SWVersion    string     `json:"sw_version,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haDiscovery is a Home Assistant MQTT discovery config for a single sensor or binary sensor
+type haDiscovery struct {
+	Name                   string           `json:"name"`
+	UniqueID               string           `json:"unique_id"`
+	ObjectID               string           `json:"object_id"`
+	StateTopic             string           `json:"state_topic"`
+	ValueTemplate          string           `json:"value_template"`
+	JSONAttributesTopic    string           `json:"json_attributes_topic,omitempty"`
+	JSONAttributesTemplate string           `json:"json_attributes_template,omitempty"`
+	DeviceClass            string           `json:"device_class,omitempty"`
+	StateClass             string           `json:"state_class,omitempty"`
+	UnitOfMeasurement      string           `json:"unit_of_measurement,omitempty"`
+	Icon                   string           `json:"icon,omitempty"`
+	Availability           []haAvailability `json:"availability"`
+	AvailabilityMode       string           `json:"availability_mode"`
+	Device                 haDevice         `json:"device"`
+
+	component string
+}
+
+func newMQTTPublisher(config *mqttConfig, connection mqttConnection) *mqttPublisher {
+	return &mqttPublisher{
+		config:     config,
+		connection: connection,
+		discovery:  map[string]string{},
+		online:     map[string]bool{},
+		pending:    map[string]*mqttSnapshot{},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// connectMQTT connects to the broker, with the exporter's availability topic as its last will. The connection is
+// retried in the background if the broker can't be reached yet, and every time that it's lost.
+func connectMQTT(config *mqttConfig) (*mqttPublisher, error) {
+	c := &pahoConnection{timeout: config.Timeout}
+	p := newMQTTPublisher(config, c)
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetWill(p.statusTopic(), mqttOffline, mqttQoS, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) { p.connected() }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			level.Warn(logger).Log("msg", "Lost connection to the MQTT broker", "broker", config.Broker, "err", err)
+		})
+
+	c.client = mqtt.NewClient(options)
+
+	token := c.client.Connect()
+	if token.WaitTimeout(config.Timeout) && token.Error() != nil {
+		return nil, fmt.Errorf("unable to connect to the MQTT broker: %w", token.Error())
+	}
+
+	return p, nil
+}
+
+// connected announces that the exporter is online and listens for Home Assistant restarts. It's called every time
+// that the connection to the broker is made.
+func (p *mqttPublisher) connected() {
+	level.Info(logger).Log("msg", "Connected to the MQTT broker", "broker", p.config.Broker)
+
+	p.Lock()
+	defer p.Unlock()
+
+	// the broker might have lost anything that was retained, so everything is published again on the next poll
+	p.discovery = map[string]string{}
+	p.online = map[string]bool{}
+
+	p.publish(p.statusTopic(), true, []byte(mqttOnline))
+
+	if p.config.DisableDiscovery {
+		return
+	}
+
+	err := p.connection.subscribe(p.config.DiscoveryPrefix+"/status", func(payload []byte) {
+		if string(payload) != mqttOnline {
+			return
+		}
+
+		level.Info(logger).Log("msg", "Home Assistant restarted. Discovery configs will be published again.")
+
+		p.Lock()
+		p.discovery = map[string]string{}
+		p.Unlock()
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "unable to subscribe to Home Assistant's status", "err", err)
+	}
+}
+
+// polled queues a device's latest readings to be published, replacing any that haven't been published yet. It
+// implements [exporter.pollListener].
+func (p *mqttPublisher) polled(device *deviceConfig, info *info, at time.Time) {
+	p.queue.Lock()
+	p.pending[mqttDeviceID(device, info.system)] = &mqttSnapshot{device: device, info: info}
+	p.queue.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+		// already woken up
+	}
+}
+
+// run publishes each poll as it's queued, and marks devices as offline once they can't be polled or their data goes
+// stale, until the context is cancelled
+func (p *mqttPublisher) run(ctx context.Context, exporters []*Exporter) {
+	ticker := time.NewTicker(mqttAvailabilityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+			p.publishPending()
+		case <-ticker.C:
+			p.checkAvailability(exporters)
+		}
+	}
+}
+
+// publishPending publishes every snapshot that has been queued since it was last called
+func (p *mqttPublisher) publishPending() {
+	p.queue.Lock()
+	pending := p.pending
+	p.pending = map[string]*mqttSnapshot{}
+	p.queue.Unlock()
+
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		p.publishSnapshot(id, pending[id])
+	}
+}
+
+// publishSnapshot publishes a device's discovery configs, its system and each of its outputs
+func (p *mqttPublisher) publishSnapshot(id string, snapshot *mqttSnapshot) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.config.DisableDiscovery {
+		p.discover(snapshot.device, snapshot.info, id)
+	}
+
+	p.publishJSON(p.systemTopic(id), snapshot.info.system.status())
+
+	for i := range *snapshot.info.outputs {
+		o := &(*snapshot.info.outputs)[i]
+		p.publishJSON(p.outputTopic(id, o.ID), o.status())
+	}
+
+	p.setAvailability(id, true)
+}
+
+// checkAvailability publishes whether each device is reachable, for every device that has been polled at least once
+func (p *mqttPublisher) checkAvailability(exporters []*Exporter) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, e := range exporters {
+		info, lastPoll, up := e.herpstat.snapshot()
+		if lastPoll.IsZero() {
+			continue
+		}
+
+		p.setAvailability(mqttDeviceID(e.herpstat.device, info.system), up && !e.herpstat.isStale(lastPoll))
+	}
+}
+
+// setAvailability publishes a device's availability, but only when it changes
+func (p *mqttPublisher) setAvailability(id string, online bool) {
+	if previous, ok := p.online[id]; ok && previous == online {
+		return
+	}
+
+	payload := mqttOffline
+	if online {
+		payload = mqttOnline
+	}
+
+	if p.publish(p.availabilityTopic(id), true, []byte(payload)) {
+		p.online[id] = online
+	}
+}
+
+// discover publishes a discovery config for each of a device's sensors whenever it changes, and removes the configs
+// for any sensors that have gone away (eg: because an output's mode changed)
+func (p *mqttPublisher) discover(device *deviceConfig, info *info, id string) {
+	configs := p.discoveryConfigs(device, info, id)
+	current := map[string]bool{}
+
+	for _, c := range configs {
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", p.config.DiscoveryPrefix, c.component, id, strings.TrimPrefix(c.ObjectID, id+"_"))
+		current[topic] = true
+
+		payload, err := json.Marshal(c)
+		if err != nil {
+			level.Error(logger).Log("msg", "unable to encode Home Assistant discovery config", "err", err)
+			continue
+		}
+
+		if p.discovery[topic] == string(payload) {
+			continue
+		}
+
+		if p.publish(topic, true, payload) {
+			p.discovery[topic] = string(payload)
+		}
+	}
+
+	prefix := p.config.DiscoveryPrefix + "/"
+	suffix := "/" + id + "/"
+
+	stale := []string{}
+
+	for topic := range p.discovery {
+		if strings.HasPrefix(topic, prefix) && strings.Contains(topic, suffix) && !current[topic] {
+			stale = append(stale, topic)
+		}
+	}
+
+	sort.Strings(stale)
+
+	// an empty retained config removes the sensor from Home Assistant
+	for _, topic := range stale {
+		if p.publish(topic, true, []byte{}) {
+			delete(p.discovery, topic)
+		}
+	}
+}
+
+// discoveryConfigs returns the Home Assistant discovery config for every sensor that the device has. Outputs get
+// sensors for whatever makes sense for their mode.
+func (p *mqttPublisher) discoveryConfigs(device *deviceConfig, info *info, id string) []*haDiscovery {
+	name := device.Name
+	if name == "" {
+		name = info.system.Name
+	}
+
+	d := haDevice{
+		Identifiers:  []string{id},
+		Name:         name,
+		Manufacturer: "Spyder Robotics",
+		Model:        "Herpstat SpyderWeb",
+		SWVersion:    info.system.firmware(),
+	}
+
+	if info.system.Mac != "" {
+		d.Connections = [][]string{{"mac", strings.ToLower(info.system.Mac)}}
+	}
+
+	availability := []haAvailability{{Topic: p.statusTopic()}, {Topic: p.availabilityTopic(id)}}
+
+	sensor := func(component, objectID, name, stateTopic, valueTemplate string) *haDiscovery {
+		return &haDiscovery{
+			Name:             name,
+			UniqueID:         id + "_" + objectID,
+			ObjectID:         id + "_" + objectID,
+			StateTopic:       stateTopic,
+			ValueTemplate:    valueTemplate,
+			Availability:     availability,
+			AvailabilityMode: "all",
+			Device:           d,
+			component:        component,
+		}
+	}
+
+	internal := sensor("sensor", "internal_temperature", "Internal Temperature", p.systemTopic(id), "{{ value_json.internal_temperature_celsius }}")
+	internal.DeviceClass, internal.StateClass, internal.UnitOfMeasurement = "temperature", "measurement", "°C"
+
+	relay := sensor("binary_sensor", "safety_relay", "Safety Relay", p.systemTopic(id), "{{ 'ON' if value_json.safety_relay in ['high_temperature', 'tripped'] else 'OFF' }}")
+	relay.DeviceClass = "problem"
+
+	configs := []*haDiscovery{internal, relay}
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+
+		topic := p.outputTopic(id, o.ID)
+		prefix := "output" + o.ID + "_"
+
+		label := fmt.Sprintf("Output %s", o.ID)
+		if o.Name != "" {
+			label = o.Name
+		}
+
+		power := sensor("sensor", prefix+"power", label+" Power", topic, "{{ value_json.power_percent }}")
+		power.StateClass, power.UnitOfMeasurement, power.Icon = "measurement", "%", "mdi:flash"
+
+		errorCode := sensor("sensor", prefix+"error", label+" Error", topic, "{{ value_json.error.reason }}")
+		errorCode.JSONAttributesTopic, errorCode.JSONAttributesTemplate, errorCode.Icon = topic, "{{ value_json.error | tojson }}", "mdi:alert-circle-outline"
+
+		configs = append(configs, power, errorCode)
+
+		if !o.mode.hasProbe() {
+			continue
+		}
+
+		reading := sensor("sensor", prefix+fieldTemperature, label+" Temperature", topic, "{{ value_json.reading }}")
+		reading.DeviceClass, reading.StateClass, reading.UnitOfMeasurement = "temperature", "measurement", "°C"
+
+		if o.measuresHumidity() {
+			reading = sensor("sensor", prefix+fieldHumidity, label+" Humidity", topic, "{{ value_json.reading }}")
+			reading.DeviceClass, reading.StateClass, reading.UnitOfMeasurement = "humidity", "measurement", "%"
+		}
+
+		alarm := sensor("binary_sensor", prefix+"alarm", label+" Alarm", topic, "{{ 'ON' if value_json.alarm is defined and value_json.alarm.active is defined else 'OFF' }}")
+		alarm.DeviceClass, alarm.JSONAttributesTopic, alarm.JSONAttributesTemplate = "problem", topic, "{{ value_json.alarm | tojson }}"
+
+		configs = append(configs, reading, alarm)
+	}
+
+	return configs
+}
+
+func (p *mqttPublisher) publishJSON(topic string, body interface{}) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		level.Error(logger).Log("msg", "unable to encode MQTT message", "topic", topic, "err", err)
+		return
+	}
+
+	p.publish(topic, true, payload)
+}
+
+// publish sends a message, logging it if it can't be sent
+func (p *mqttPublisher) publish(topic string, retained bool, payload []byte) bool {
+	err := p.connection.publish(topic, retained, payload)
+
+	switch {
+	case errors.Is(err, errMQTTDisconnected):
+		// there's no point in logging every message while the broker is down, since losing it has already been logged
+		level.Debug(logger).Log("msg", "not publishing to MQTT", "topic", topic, "err", err)
+		return false
+	case err != nil:
+		level.Error(logger).Log("msg", "unable to publish to MQTT", "topic", topic, "err", err)
+		return false
+	}
+
+	return true
+}
+
+// statusTopic is the exporter's own availability, which is also its last will
+func (p *mqttPublisher) statusTopic() string {
+	return p.config.TopicPrefix + "/status"
+}
+
+func (p *mqttPublisher) availabilityTopic(id string) string {
+	return fmt.Sprintf("%s/%s/availability", p.config.TopicPrefix, id)
+}
+
+func (p *mqttPublisher) systemTopic(id string) string {
+	return fmt.Sprintf("%s/%s/system", p.config.TopicPrefix, id)
+}
+
+func (p *mqttPublisher) outputTopic(id, outputID string) string {
+	return fmt.Sprintf("%s/%s/output/%s", p.config.TopicPrefix, id, outputID)
+}
+
+// mqttDeviceID is a stable ID for a device, used in its topics and Home Assistant IDs. It's based on the device's
+// MAC address where possible, so that it doesn't change if the device is renamed or moves to a new address.
+func mqttDeviceID(device *deviceConfig, s *system) string {
+	id := s.Mac
+	if id == "" {
+		id = device.Address
+	}
+
+	return "herpstat_" + strings.Trim(mqttUnsafeCharacters.ReplaceAllString(strings.ToLower(id), "_"), "_")
+}
+
+// pahoConnection is an [exporter.mqttConnection] backed by a real broker
+type pahoConnection struct {
+	client  mqtt.Client
+	timeout time.Duration
+}
+
+func (c *pahoConnection) publish(topic string, retained bool, payload []byte) error {
+	if !c.client.IsConnectionOpen() {
+		return errMQTTDisconnected
+	}
+
+	return c.wait(c.client.Publish(topic, mqttQoS, retained, payload))
+}
+
+func (c *pahoConnection) subscribe(topic string, handler func(payload []byte)) error {
+	return c.wait(c.client.Subscribe(topic, mqttQoS, func(_ mqtt.Client, m mqtt.Message) {
+		handler(m.Payload())
+	}))
+}
+
+func (c *pahoConnection) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(c.timeout) {
+		return errors.New("timed out waiting for the MQTT broker")
+	}
+
+	return token.Error()
+}
@@ -0,0 +1,279 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+)
+
+// fakeMQTT is an [exporter.mqttConnection] that remembers the last message published to each topic
+type fakeMQTT struct {
+	sync.Mutex
+
+	down          bool
+	published     int
+	messages      map[string]string
+	subscriptions map[string]func(payload []byte)
+}
+
+func newFakeMQTT() *fakeMQTT {
+	return &fakeMQTT{messages: map[string]string{}, subscriptions: map[string]func(payload []byte){}}
+}
+
+func (f *fakeMQTT) publish(topic string, retained bool, payload []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.down {
+		return errMQTTDisconnected
+	}
+
+	f.published++
+	f.messages[topic] = string(payload)
+
+	return nil
+}
+
+func (f *fakeMQTT) subscribe(topic string, handler func(payload []byte)) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.subscriptions[topic] = handler
+
+	return nil
+}
+
+// discovery returns every discovery config that's currently retained, by topic
+func (f *fakeMQTT) discovery() map[string]string {
+	f.Lock()
+	defer f.Unlock()
+
+	configs := map[string]string{}
+
+	for topic, payload := range f.messages {
+		if strings.HasPrefix(topic, defaultMQTTDiscoveryPrefix+"/") && strings.HasSuffix(topic, "/config") && payload != "" {
+			configs[topic] = payload
+		}
+	}
+
+	return configs
+}
+
+func (f *fakeMQTT) message(topic string) string {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.messages[topic]
+}
+
+func (f *fakeMQTT) count() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.published
+}
+
+func TestMQTTConfig(t *testing.T) {
+	c := &mqttConfig{}
+	if err := c.validate(); err != nil || c.enabled() {
+		t.Errorf("expected mqtt to be disabled without a broker, got %v", err)
+	}
+
+	c = &mqttConfig{Broker: "tcp://localhost:1883"}
+	if err := c.validate(); err != nil || c.TopicPrefix != defaultMQTTTopicPrefix || c.DiscoveryPrefix != defaultMQTTDiscoveryPrefix {
+		t.Errorf("expected the defaults to be filled in, got %+v (%v)", c, err)
+	}
+
+	c = &mqttConfig{Broker: "tcp://localhost:1883", TopicPrefix: "herpstat/#"}
+	if err := c.validate(); err == nil {
+		t.Error("expected a wildcard in the topic prefix to be rejected")
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	device := simulator.New(simulator.Config{Nickname: "Reptile Room", Outputs: 4})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	e := newExporter(newDeviceConfig(strings.TrimPrefix(server.URL, "http://")))
+	e.herpstat.device.MaxStaleness = time.Minute
+
+	config := &mqttConfig{Broker: "tcp://localhost:1883"}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	broker := newFakeMQTT()
+	p := newMQTTPublisher(config, broker)
+	p.connected()
+
+	if got := broker.message("herpstat/status"); got != mqttOnline {
+		t.Errorf("expected the exporter to be online, got %q", got)
+	}
+
+	poll := func() *info {
+		t.Helper()
+
+		if !e.herpstat.refresh() {
+			t.Fatal("unable to poll the simulator")
+		}
+
+		info, _, _ := e.herpstat.snapshot()
+		p.polled(e.herpstat.device, info, time.Now())
+		p.publishPending()
+
+		return info
+	}
+
+	info := poll()
+	id := mqttDeviceID(e.herpstat.device, info.system)
+
+	if got := broker.message("herpstat/" + id + "/availability"); got != mqttOnline {
+		t.Errorf("expected the device to be online, got %q", got)
+	}
+
+	var output outputStatus
+	if err := json.Unmarshal([]byte(broker.message("herpstat/"+id+"/output/3")), &output); err != nil || output.Mode != modeHumidity || output.Reading == nil {
+		t.Errorf("expected output 3's state, got %+v (%v)", output, err)
+	}
+
+	// system: internal temperature and safety relay. outputs: power and error, plus a reading and alarm if they
+	// have a probe
+	configs := broker.discovery()
+	if len(configs) != 2+4*2+3*2 {
+		t.Errorf("expected 16 discovery configs, got %d: %v", len(configs), configs)
+	}
+
+	var humidity haDiscovery
+	if err := json.Unmarshal([]byte(configs["homeassistant/sensor/"+id+"/output3_humidity/config"]), &humidity); err != nil || humidity.DeviceClass != "humidity" || humidity.StateTopic != "herpstat/"+id+"/output/3" {
+		t.Errorf("expected a humidity sensor for output 3, got %+v (%v)", humidity, err)
+	}
+
+	// nothing changed, so only the state is published again
+	published := broker.count()
+	poll()
+
+	if got := broker.count() - published; got != 5 {
+		t.Errorf("expected only the system and output states to be published again, got %d messages", got)
+	}
+
+	// output 1 doesn't have a probe anymore, so its reading and alarm go away
+	device.UpdateOutput(1, func(o *simulator.Output) { o.Mode = "Timer" })
+	poll()
+
+	if configs := broker.discovery(); len(configs) != 14 {
+		t.Errorf("expected output 1's probe sensors to be removed, got %d configs", len(configs))
+	}
+
+	// everything is published again once Home Assistant restarts
+	broker.subscriptions["homeassistant/status"]([]byte(mqttOnline))
+
+	published = broker.count()
+	poll()
+
+	if got := broker.count() - published; got != 5+14 {
+		t.Errorf("expected discovery to be published again, got %d messages", got)
+	}
+
+	// the device goes offline once its data is stale
+	e.herpstat.Lock()
+	e.herpstat.lastPoll = time.Now().Add(-time.Hour)
+	e.herpstat.Unlock()
+
+	p.checkAvailability([]*Exporter{e})
+
+	if got := broker.message("herpstat/" + id + "/availability"); got != mqttOffline {
+		t.Errorf("expected the device to be offline, got %q", got)
+	}
+
+	// nothing is remembered as published while the broker is down
+	broker.down = true
+	p.setAvailability(id, true)
+	broker.down = false
+	p.setAvailability(id, true)
+
+	if got := broker.message("herpstat/" + id + "/availability"); got != mqttOnline {
+		t.Errorf("expected the device to be back online once the broker is, got %q", got)
+	}
+}
+
+// blockingMQTT is an [exporter.mqttConnection] for a broker that never acknowledges anything until it's released
+type blockingMQTT struct {
+	release chan struct{}
+}
+
+func (b *blockingMQTT) publish(topic string, retained bool, payload []byte) error {
+	<-b.release
+	return nil
+}
+
+func (b *blockingMQTT) subscribe(topic string, handler func(payload []byte)) error {
+	return nil
+}
+
+func TestMQTTPublisherDoesNotBlockPolling(t *testing.T) {
+	server := httptest.NewServer(simulator.New(simulator.Config{Outputs: 4}))
+	t.Cleanup(server.Close)
+
+	config := &mqttConfig{Broker: "tcp://localhost:1883"}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	broker := &blockingMQTT{release: make(chan struct{})}
+	p := newMQTTPublisher(config, broker)
+
+	e := newExporter(newDeviceConfig(strings.TrimPrefix(server.URL, "http://")))
+	e.herpstat.listeners = []pollListener{p}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		p.run(ctx, []*Exporter{e})
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		close(broker.release)
+		cancel()
+		<-done
+	})
+
+	// the first poll is picked up by run, which then waits on the broker forever
+	polled := make(chan bool)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			if !e.herpstat.refresh() {
+				polled <- false
+				return
+			}
+		}
+
+		polled <- true
+	}()
+
+	select {
+	case ok := <-polled:
+		if !ok {
+			t.Fatal("unable to poll the simulator")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("polling was blocked by the MQTT broker")
+	}
+
+	// only the latest snapshot is kept while the broker is stuck
+	p.queue.Lock()
+	defer p.queue.Unlock()
+
+	if len(p.pending) > 1 {
+		t.Errorf("expected at most one pending snapshot per device, got %d", len(p.pending))
+	}
+}
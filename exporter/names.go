@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/go-kit/log/level"
+)
+
+// systemNames makes sure that every device under /metrics ends up with its own "system" label, since that's the only
+// thing that tells their readings apart. Devices without a name in the config file go by their own nickname, which
+// is often still the factory default, so a device whose nickname is already taken goes by its address instead.
+type systemNames struct {
+	sync.Mutex
+
+	// owners maps each name to the address of the device that's using it
+	owners map[string]string
+
+	// claimed maps each address to the name that it's using
+	claimed map[string]string
+}
+
+func newSystemNames() *systemNames {
+	return &systemNames{
+		owners:  map[string]string{},
+		claimed: map[string]string{},
+	}
+}
+
+// claim returns the name that the device at the given address should be exported as, which is the given name unless
+// another device already has it. A device that's renamed gives up its old name. A nil [exporter.systemNames], as
+// used by /probe targets that aren't in the config file, always returns the given name.
+func (n *systemNames) claim(address, name string) string {
+	if n == nil {
+		return name
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	if owner, ok := n.owners[name]; ok && owner != address {
+		if n.claimed[address] != address {
+			level.Warn(logger).Log("msg", "Another device already has this name, so this one is named after its address instead. Give each device its own name in the config file.", "address", address, "name", name, "other_address", owner)
+		}
+
+		name = address
+	}
+
+	if previous, ok := n.claimed[address]; ok && previous != name {
+		delete(n.owners, previous)
+	}
+
+	n.owners[name] = address
+	n.claimed[address] = name
+
+	return name
+}
@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	probeTargetParam = "target"
+
+	// targets that aren't in the config file are forgotten once they haven't been probed for this long, and the
+	// least recently probed one is forgotten to make room once there are this many of them. this keeps anyone who
+	// can reach /probe from using up memory by making up targets.
+	probeTargetTTL  = 10 * time.Minute
+	maxProbeTargets = 256
+)
+
+// targets keeps track of every Herpstat SpyderWeb that has been requested via [exporter.probeHandler]. Each one
+// gets its own [exporter.Exporter] so that it keeps its own [exporter.herpstat.NextAllowedPoll] cache between
+// scrapes.
+type targets struct {
+	sync.Mutex
+
+	// configured holds the devices from the config file, which are always kept
+	configured map[string]*Exporter
+
+	// probed holds every other target, along with when it was last probed
+	probed map[string]*probedTarget
+}
+
+type probedTarget struct {
+	exporter *Exporter
+	lastUsed time.Time
+}
+
+// newTargets seeds the list of targets with the already-configured devices, so that probing one of them by its
+// address or name shares its cache with /metrics rather than polling it a second time.
+func newTargets(exporters ...*Exporter) *targets {
+	t := &targets{
+		configured: map[string]*Exporter{},
+		probed:     map[string]*probedTarget{},
+	}
+
+	for _, e := range exporters {
+		t.configured[e.herpstat.device.Address] = e
+
+		if e.herpstat.device.Name != "" {
+			t.configured[e.herpstat.device.Name] = e
+		}
+	}
+
+	return t
+}
+
+// get returns the [exporter.Exporter] for the given target, creating a new one with the default poll settings if
+// we haven't seen it recently.
+func (t *targets) get(target string) *Exporter {
+	t.Lock()
+	defer t.Unlock()
+
+	if e, ok := t.configured[target]; ok {
+		return e
+	}
+
+	now := time.Now()
+	t.evict(now)
+
+	p, ok := t.probed[target]
+	if !ok {
+		level.Info(logger).Log("msg", "Probing new target", "target", target)
+
+		e := newExporter(newDeviceConfig(target))
+		e.pollOnScrape = true
+
+		p = &probedTarget{exporter: e}
+		t.probed[target] = p
+	}
+
+	p.lastUsed = now
+
+	return p.exporter
+}
+
+// evict forgets every probed target that has been idle for longer than [exporter.probeTargetTTL], then the least
+// recently probed ones until there's room for another
+func (t *targets) evict(now time.Time) {
+	for target, p := range t.probed {
+		if now.Sub(p.lastUsed) > probeTargetTTL {
+			level.Debug(logger).Log("msg", "Forgetting idle target", "target", target)
+			delete(t.probed, target)
+		}
+	}
+
+	for len(t.probed) >= maxProbeTargets {
+		oldest := ""
+
+		for target, p := range t.probed {
+			if oldest == "" || p.lastUsed.Before(t.probed[oldest].lastUsed) {
+				oldest = target
+			}
+		}
+
+		level.Debug(logger).Log("msg", "Forgetting least recently probed target", "target", oldest)
+		delete(t.probed, oldest)
+	}
+}
+
+// probeHandler works like the blackbox_exporter's /probe endpoint. It responds with the metrics for the Herpstat
+// SpyderWeb given via the ?target= query parameter, which lets a single exporter serve many devices. Targets that
+// aren't already being polled in the background are polled when they're scraped.
+//
+//	/probe?target=1.2.3.4
+func probeHandler(t *targets) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get(probeTargetParam)
+		if target == "" {
+			http.Error(w, "'target' parameter must be specified", http.StatusBadRequest)
+			return
+		}
+
+		level.Debug(logger).Log("msg", "probe was called", "target", target)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(t.get(target))
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,53 @@
+package exporter
+
+import "strings"
+
+// reasons that an output's probe can be faulted, used as the "reason" label on herpstat_output_probe_fault
+const (
+	probeFaultDisconnected   = "disconnected"
+	probeFaultShorted        = "shorted"
+	probeFaultError          = "error"
+	probeFaultInvalidReading = "invalid_reading"
+)
+
+// probeFaults is every reason in the order they're exported in the herpstat_output_probe_fault state set
+var probeFaults = []string{probeFaultDisconnected, probeFaultShorted, probeFaultError, probeFaultInvalidReading}
+
+// detectProbeFault works out whether the device is telling us that this output's probe is missing or faulted, based
+// on its error. We don't know what reading an unplugged probe reports, so a garbage reading without an error is left
+// to [exporter.validator], which shows up as an invalid_reading fault instead. This needs to happen before the
+// validator gets a chance to replace any readings with the last good ones.
+func (o *output) detectProbeFault() string {
+	desc := strings.ToLower(o.ErrorDesc)
+
+	switch {
+	case strings.Contains(desc, "disconnect"),
+		strings.Contains(desc, "not connected"),
+		strings.Contains(desc, "no probe"):
+		return probeFaultDisconnected
+	case strings.Contains(desc, "short"):
+		return probeFaultShorted
+	case o.ErrorCode != 0 && strings.Contains(desc, "probe"):
+		return probeFaultError
+	default:
+		return ""
+	}
+}
+
+// probeStatus returns why this output's probe is faulted, or "" if it looks fine. Besides anything the device
+// told us, a reading that was rejected by [exporter.validator] counts as a fault.
+func (o *output) probeStatus() string {
+	if o.fault != "" {
+		return o.fault
+	}
+
+	if _, ok := o.probeReading(); !ok {
+		return probeFaultInvalidReading
+	}
+
+	return ""
+}
+
+func (o *output) probeFaultLabelValues(systemName *string, reason string) []string {
+	return []string{*systemName, o.ID, reason}
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDetectProbeFault(t *testing.T) {
+	tests := []struct {
+		name string
+		o    output
+		want string
+	}{
+		{"healthy", output{mode: modeHeating, ProbeTemp: 90, ErrorDesc: "No Error"}, ""},
+		// error codes don't mean anything on their own, since we don't know what they are
+		{"error code", output{mode: modeHeating, ProbeTemp: 90, ErrorCode: 1}, ""},
+		{"error description", output{mode: modeHeating, ProbeTemp: 90, ErrorCode: 7, ErrorDesc: "Probe Not Connected"}, probeFaultDisconnected},
+		// a garbage reading on its own is left to validation, since we don't know what an unplugged probe reports
+		{"garbage reading", output{mode: modeHeating, ProbeTemp: 6553.5}, ""},
+		{"shorted", output{mode: modeHeating, ErrorCode: 2, ErrorDesc: "Probe Shorted"}, probeFaultShorted},
+		{"other probe error", output{mode: modeHeating, ErrorCode: 3, ErrorDesc: "Probe Error"}, probeFaultError},
+		{"other error", output{mode: modeHeating, ProbeTemp: 90, ErrorCode: 4, ErrorDesc: "Overcurrent"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.o.detectProbeFault(); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCollectProbeDisconnected(t *testing.T) {
+	device := simulator.New(simulator.Config{Outputs: 1})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	d := newDeviceConfig(strings.TrimPrefix(server.URL, "http://"))
+	// holding the last good reading shouldn't hide a disconnected probe
+	d.Validation.HoldLastGood = 5
+	e := newExporter(d)
+
+	for _, tt := range []struct {
+		unplug    bool
+		connected string
+		fault     string
+	}{
+		{false, "1", "0"},
+		{true, "0", "1"},
+		{false, "1", "0"},
+	} {
+		if tt.unplug {
+			device.UnplugProbe(1)
+		} else {
+			device.PlugProbe(1)
+		}
+
+		if !e.herpstat.refresh() {
+			t.Fatal("unable to poll the simulator")
+		}
+
+		expected := `
+# HELP herpstat_output_probe_connected Is the output's probe plugged in?
+# TYPE herpstat_output_probe_connected gauge
+herpstat_output_probe_connected{output="1",system="Herpstat Simulator"} ` + tt.connected + `
+# HELP herpstat_output_probe_fault Why the output's probe is faulted. At most one reason is 1 at a time.
+# TYPE herpstat_output_probe_fault gauge
+herpstat_output_probe_fault{output="1",reason="disconnected",system="Herpstat Simulator"} ` + tt.fault + `
+herpstat_output_probe_fault{output="1",reason="error",system="Herpstat Simulator"} 0
+herpstat_output_probe_fault{output="1",reason="invalid_reading",system="Herpstat Simulator"} 0
+herpstat_output_probe_fault{output="1",reason="shorted",system="Herpstat Simulator"} 0
+`
+
+		if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "herpstat_output_probe_connected", "herpstat_output_probe_fault"); err != nil {
+			t.Errorf("unplugged: %v: %s", tt.unplug, err)
+		}
+	}
+}
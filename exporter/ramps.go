@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// rampState is a parsed version of an output's free-form "ramping" status
+type rampState string
+
+const (
+	rampNotInSession rampState = "not_in_session"
+	rampUp           rampState = "ramping_up"
+	rampDown         rampState = "ramping_down"
+	rampUnknown      rampState = "unknown"
+
+	rampResultCompleted = "completed"
+	rampResultAborted   = "aborted"
+
+	// how far along a ramp needs to get before it counts as completed rather than aborted
+	rampCompletedProgress = 0.99
+)
+
+var (
+	// rampStates is every state in the order they're exported in the herpstat_output_ramp_state state set
+	rampStates  = []rampState{rampNotInSession, rampUp, rampDown, rampUnknown}
+	rampResults = []string{rampResultCompleted, rampResultAborted}
+)
+
+// parseRampState turns the device's ramping status (eg: "Ramping Up") into a [exporter.rampState]. Anything that
+// isn't "Not In Session" counts as being in a session, even if we don't recognize it.
+func parseRampState(raw string) rampState {
+	ramping := strings.ToLower(strings.TrimSpace(raw))
+
+	switch {
+	case raw == rampingOff, ramping == "", strings.Contains(ramping, "not in session"):
+		return rampNotInSession
+	case strings.Contains(ramping, "up"):
+		return rampUp
+	case strings.Contains(ramping, "down"):
+		return rampDown
+	default:
+		return rampUnknown
+	}
+}
+
+func (s rampState) inSession() bool {
+	return s != rampNotInSession
+}
+
+// rampProgress is how far the output's current setpoint has moved from the start of the ramp to its end, from 0 to
+// 1. The device's own ramp start setting is used if it has one, otherwise the setpoint from when we first saw the
+// session is.
+func (o *output) rampProgress(startValue float64) (float64, bool) {
+	if o.Setpoint == nil {
+		return 0, false
+	}
+
+	start := startValue
+	if o.RampStart != nil {
+		start = *o.RampStart
+	}
+
+	span := o.RampEnd - start
+	if span == 0 {
+		return 0, false
+	}
+
+	return math.Max(0, math.Min(1, (*o.Setpoint-start)/span)), true
+}
+
+// rampSession is a single ramp, from when we first saw it
+type rampSession struct {
+	started       time.Time
+	startValue    float64
+	startProgress float64
+	progress      float64
+	hasProgress   bool
+	eta           time.Duration
+}
+
+// rampHistory is everything that we know about an output's ramps
+type rampHistory struct {
+	session      *rampSession
+	results      map[string]float64
+	lastDuration time.Duration
+}
+
+// rampTracker follows each output's ramp sessions across polls, working out their progress and when they should
+// finish, and counting them once they're over
+type rampTracker struct {
+	sync.Mutex
+
+	outputs map[string]*rampHistory
+}
+
+func newRampTracker() *rampTracker {
+	return &rampTracker{outputs: map[string]*rampHistory{}}
+}
+
+// update moves every output's ramp session along with a freshly polled [exporter.info]
+func (r *rampTracker) update(device *deviceConfig, info *info, at time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+		if !o.mode.hasProbe() {
+			continue
+		}
+
+		history, ok := r.outputs[o.ID]
+		if !ok {
+			history = &rampHistory{results: map[string]float64{}}
+			r.outputs[o.ID] = history
+		}
+
+		state := parseRampState(o.Ramping)
+
+		switch {
+		case state.inSession() && history.session == nil:
+			history.session = &rampSession{started: at}
+			if o.Setpoint != nil {
+				history.session.startValue = *o.Setpoint
+			}
+
+			history.session.startProgress, history.session.hasProgress = o.rampProgress(history.session.startValue)
+			history.session.progress = history.session.startProgress
+
+			level.Info(logger).Log("msg", "Ramp session started", "event", "ramp_started", "address", device.Address, "system", info.system.Name, "output", o.ID, "state", state, "end", o.RampEnd)
+		case state.inSession():
+			history.session.update(o, at)
+		case history.session != nil:
+			r.finish(device, info.system.Name, o, history, at)
+		}
+	}
+}
+
+// update recalculates a session's progress and estimates how long it has left, assuming that it carries on at the
+// same rate that it has since we first saw it
+func (s *rampSession) update(o *output, at time.Time) {
+	s.progress, s.hasProgress = o.rampProgress(s.startValue)
+	s.eta = 0
+
+	elapsed, gained := at.Sub(s.started), s.progress-s.startProgress
+	if s.hasProgress && gained > 0 && elapsed > 0 {
+		s.eta = time.Duration(float64(elapsed) * (1 - s.progress) / gained)
+	}
+}
+
+// finish counts an output's ramp session once it's no longer in session. It counts as aborted if the setpoint
+// didn't make it to the end of the ramp. If we can't tell how far it got, the device is trusted to have finished it.
+func (r *rampTracker) finish(device *deviceConfig, systemName string, o *output, history *rampHistory, at time.Time) {
+	session := history.session
+	progress, known := session.progress, session.hasProgress
+
+	if final, ok := o.rampProgress(session.startValue); ok {
+		known = true
+		progress = math.Max(progress, final)
+	}
+
+	result := rampResultCompleted
+	if known && progress < rampCompletedProgress {
+		result = rampResultAborted
+	}
+
+	history.results[result]++
+	history.lastDuration = at.Sub(session.started)
+	history.session = nil
+
+	level.Info(logger).Log("msg", "Ramp session ended", "event", "ramp_"+result, "address", device.Address, "system", systemName, "output", o.ID, "duration", history.lastDuration, "progress", progress)
+}
+
+// status returns a copy of everything we know about an output's ramps
+func (r *rampTracker) status(outputID string) (session *rampSession, results map[string]float64, lastDuration time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	results = map[string]float64{}
+
+	history, ok := r.outputs[outputID]
+	if !ok {
+		return nil, results, 0
+	}
+
+	for result, count := range history.results {
+		results[result] = count
+	}
+
+	if history.session != nil {
+		s := *history.session
+		session = &s
+	}
+
+	return session, results, history.lastDuration
+}
+
+func (o *output) rampStateLabelValues(systemName *string, state rampState) []string {
+	return []string{*systemName, o.ID, string(state)}
+}
+
+func (o *output) rampResultLabelValues(systemName *string, result string) []string {
+	return []string{*systemName, o.ID, result}
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRampState(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want rampState
+	}{
+		{"Not In Session", rampNotInSession},
+		{"", rampNotInSession},
+		{"Ramping Up", rampUp},
+		{"Ramping Down", rampDown},
+		{"Holding", rampUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := parseRampState(tt.raw); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.raw, tt.want, got)
+		}
+	}
+}
+
+func TestRampTracker(t *testing.T) {
+	r := newRampTracker()
+	device := newDeviceConfig(fixtureAddress)
+	start := time.Now()
+
+	steps := []struct {
+		after     time.Duration
+		ramping   string
+		setpoint  float64
+		progress  float64
+		eta       time.Duration
+		completed float64
+		aborted   float64
+	}{
+		{0, "Ramping Up", 20, 0, 0, 0, 0},
+		{time.Hour, "Ramping Up", 22.5, 0.25, 3 * time.Hour, 0, 0},
+		{2 * time.Hour, "Ramping Up", 25, 0.5, 2 * time.Hour, 0, 0},
+		{4 * time.Hour, "Not In Session", 30, -1, 0, 1, 0},
+		{5 * time.Hour, "Ramping Up", 20, 0, 0, 1, 0},
+		{6 * time.Hour, "Not In Session", 21, -1, 0, 1, 1},
+	}
+
+	for _, step := range steps {
+		r.update(device, newTestInfo(system{Name: "Ramps"}, output{mode: modeHeating, Ramping: step.ramping, Setpoint: float64Ptr(step.setpoint), RampEnd: 30}), start.Add(step.after))
+
+		session, results, _ := r.status("1")
+
+		switch {
+		case step.progress < 0 && session != nil:
+			t.Errorf("%s: expected the session to be over", step.after)
+		case step.progress >= 0 && session == nil:
+			t.Errorf("%s: expected a session", step.after)
+		case session != nil && (session.progress != step.progress || session.eta != step.eta):
+			t.Errorf("%s: expected progress %v and eta %s, got %v and %s", step.after, step.progress, step.eta, session.progress, session.eta)
+		}
+
+		if results[rampResultCompleted] != step.completed || results[rampResultAborted] != step.aborted {
+			t.Errorf("%s: expected %v completed and %v aborted, got %v", step.after, step.completed, step.aborted, results)
+		}
+	}
+
+	if _, _, lastDuration := r.status("1"); lastDuration != time.Hour {
+		t.Errorf("expected the last session to have taken 1h, got %s", lastDuration)
+	}
+}
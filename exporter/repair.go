@@ -0,0 +1,297 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// kinds of repairs that [exporter.repairRawstatus] can make, used as the "kind" label on herpstat_parse_repairs_total
+const (
+	repairTruncated         = "truncated"
+	repairControlCharacters = "control_characters"
+	repairDuplicateCommas   = "duplicate_commas"
+	repairNaN               = "nan"
+	repairDroppedObject     = "dropped_object"
+)
+
+var (
+	repairKinds = []string{repairTruncated, repairControlCharacters, repairDuplicateCommas, repairNaN, repairDroppedObject}
+
+	// tokens that some firmware sends in place of a number when a reading goes haywire. longer tokens come first so
+	// that "-Infinity" isn't mistaken for "-inf".
+	nanTokens = [][]byte{
+		[]byte("-Infinity"), []byte("Infinity"), []byte("-NaN"), []byte("NaN"),
+		[]byte("-nan"), []byte("nan"), []byte("-inf"), []byte("inf"),
+	}
+
+	errUnrepairable = errors.New("unable to salvage the system data from the response")
+)
+
+// repairRawstatus is a lenient fallback for when a Herpstat SpyderWeb's /RAWSTATUS response isn't valid JSON. It
+// cleans up stray control characters, duplicated commas and NaN-like tokens, then keeps every top-level "system" and
+// "output#" object that's still intact, which also takes care of truncated responses. It returns the repaired JSON
+// along with the kinds of repairs that were needed. The response can't be repaired without its "system" object.
+func repairRawstatus(data []byte) ([]byte, []string, error) {
+	kinds := map[string]bool{}
+
+	cleaned := cleanRawstatus(data, kinds)
+	members := salvageMembers(cleaned, kinds)
+
+	if _, ok := members["system"]; !ok {
+		return nil, nil, errUnrepairable
+	}
+
+	repaired, err := json.Marshal(members)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repairs := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		repairs = append(repairs, kind)
+	}
+
+	sort.Strings(repairs)
+
+	return repaired, repairs, nil
+}
+
+// cleanRawstatus removes control characters and duplicated commas, and replaces NaN-like tokens with null, which
+// [exporter.info.UnmarshalJSON] treats as an invalid reading. Anything inside of a string is left alone apart from
+// control characters, which aren't allowed there either.
+func cleanRawstatus(data []byte, kinds map[string]bool) []byte {
+	cleaned := make([]byte, 0, len(data))
+	inString, escaped := false, false
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		if isControlCharacter(c, inString) {
+			kinds[repairControlCharacters] = true
+			continue
+		}
+
+		if inString {
+			cleaned = append(cleaned, c)
+
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case ',':
+			// a comma right after another comma or the start of an object/array is a duplicate
+			if prev := lastToken(cleaned); prev == ',' || prev == '{' || prev == '[' {
+				kinds[repairDuplicateCommas] = true
+				continue
+			}
+		case '}', ']':
+			// so is a trailing comma
+			if lastToken(cleaned) == ',' {
+				kinds[repairDuplicateCommas] = true
+				cleaned = bytes.TrimRight(cleaned, " \t\r\n")
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			if token := nanToken(data[i:]); token != nil {
+				kinds[repairNaN] = true
+				cleaned = append(cleaned, "null"...)
+				i += len(token) - 1
+
+				continue
+			}
+		}
+
+		cleaned = append(cleaned, c)
+	}
+
+	return cleaned
+}
+
+// salvageMembers splits the top-level object into its members, keeping every one that's complete and valid JSON.
+// Anything after the point where the response was cut off is dropped.
+func salvageMembers(data []byte, kinds map[string]bool) map[string]json.RawMessage {
+	members := map[string]json.RawMessage{}
+
+	i := skipWhitespace(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		kinds[repairTruncated] = true
+		return members
+	}
+
+	for i++; ; {
+		i = skipWhitespace(data, i)
+		if i < len(data) && data[i] == ',' {
+			i++
+			continue
+		}
+
+		if i >= len(data) {
+			kinds[repairTruncated] = true
+			return members
+		}
+
+		if data[i] == '}' {
+			return members
+		}
+
+		keyEnd, ok := valueEnd(data, i)
+		if !ok || data[i] != '"' {
+			kinds[repairTruncated] = true
+			return members
+		}
+
+		var key string
+		if err := json.Unmarshal(data[i:keyEnd], &key); err != nil {
+			kinds[repairDroppedObject] = true
+			return members
+		}
+
+		i = skipWhitespace(data, keyEnd)
+		if i >= len(data) || data[i] != ':' {
+			kinds[repairTruncated] = true
+			return members
+		}
+
+		i = skipWhitespace(data, i+1)
+
+		end, ok := valueEnd(data, i)
+		if !ok {
+			kinds[repairTruncated] = true
+			return members
+		}
+
+		if value := data[i:end]; json.Valid(value) {
+			members[key] = value
+		} else {
+			kinds[repairDroppedObject] = true
+		}
+
+		i = end
+	}
+}
+
+// valueEnd finds where the JSON value starting at data[start] ends. Returns false if the data ends first.
+func valueEnd(data []byte, start int) (int, bool) {
+	if start >= len(data) {
+		return 0, false
+	}
+
+	switch data[start] {
+	case '{', '[':
+		depth, inString, escaped := 0, false, false
+
+		for i := start; i < len(data); i++ {
+			c := data[i]
+
+			if inString {
+				switch {
+				case escaped:
+					escaped = false
+				case c == '\\':
+					escaped = true
+				case c == '"':
+					inString = false
+				}
+
+				continue
+			}
+
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+		}
+	case '"':
+		escaped := false
+
+		for i := start + 1; i < len(data); i++ {
+			switch {
+			case escaped:
+				escaped = false
+			case data[i] == '\\':
+				escaped = true
+			case data[i] == '"':
+				return i + 1, true
+			}
+		}
+	default:
+		for i := start; i < len(data); i++ {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				return i, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// isControlCharacter checks whether a byte is a control character that doesn't belong in JSON. Tabs and newlines
+// are fine as whitespace, but not inside of a string.
+func isControlCharacter(c byte, inString bool) bool {
+	if c == '\t' || c == '\n' || c == '\r' {
+		return inString
+	}
+
+	return c < 0x20 || c == 0x7f
+}
+
+// nanToken returns the NaN-like token at the start of data, if there is one
+func nanToken(data []byte) []byte {
+	for _, token := range nanTokens {
+		if !bytes.HasPrefix(data, token) {
+			continue
+		}
+
+		// make sure that this isn't just the start of some longer word
+		if len(data) > len(token) && isLetter(data[len(token)]) {
+			continue
+		}
+
+		return token
+	}
+
+	return nil
+}
+
+// lastToken returns the last non-whitespace byte in data
+func lastToken(data []byte) byte {
+	trimmed := bytes.TrimRight(data, " \t\r\n")
+	if len(trimmed) == 0 {
+		return 0
+	}
+
+	return trimmed[len(trimmed)-1]
+}
+
+func skipWhitespace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+
+	return i
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
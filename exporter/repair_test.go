@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRepairRawstatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		repairs []string
+		outputs int
+		temp    float64
+	}{
+		{
+			name:    "truncated",
+			data:    `{"system":{"nickname":"Snakes","numberofoutputs":2,"internaltemp":95},"output1":{"outputnickname":"Heat","probereadingTEMP":90},"output2":{"outputnick`,
+			repairs: []string{repairTruncated},
+			outputs: 1,
+			temp:    95,
+		},
+		{
+			name:    "control characters",
+			data:    "{\"system\":{\"nickname\":\"Sna\x00kes\",\x07\"numberofoutputs\":2,\"internaltemp\":95},\"output1\":{\"outputnickname\":\"Heat\"}}",
+			repairs: []string{repairControlCharacters},
+			outputs: 1,
+			temp:    95,
+		},
+		{
+			name:    "duplicate and trailing commas",
+			data:    `{"system":{"nickname":"Snakes",,"numberofoutputs":2,"internaltemp":95,},"output1":{"outputnickname":"Heat, Lamp",}}`,
+			repairs: []string{repairDuplicateCommas},
+			outputs: 1,
+			temp:    95,
+		},
+		{
+			name:    "nan",
+			data:    `{"system":{"nickname":"NaN","numberofoutputs":2,"internaltemp":nan},"output1":{"outputnickname":"Heat","probereadingTEMP":-Infinity}}`,
+			repairs: []string{repairNaN},
+			outputs: 1,
+			temp:    math.NaN(),
+		},
+		{
+			name:    "dropped object",
+			data:    `{"system":{"nickname":"Snakes","numberofoutputs":2,"internaltemp":95},"output1":{"outputnickname":"Heat" "oops"},"output2":{"outputnickname":"Mist"}}`,
+			repairs: []string{repairDroppedObject},
+			outputs: 1,
+			temp:    95,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repaired, repairs, err := repairRawstatus([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("unable to repair: %s", err)
+			}
+
+			if !reflect.DeepEqual(repairs, tt.repairs) {
+				t.Errorf("expected repairs %v, got %v", tt.repairs, repairs)
+			}
+
+			polled := newInfo()
+			if err := json.Unmarshal(repaired, polled); err != nil {
+				t.Fatalf("repaired JSON still doesn't unmarshal: %s\n%s", err, repaired)
+			}
+
+			if len(*polled.outputs) != tt.outputs {
+				t.Errorf("expected %d outputs, got %d", tt.outputs, len(*polled.outputs))
+			}
+
+			if got := polled.system.Temp; got != tt.temp && !(math.IsNaN(got) && math.IsNaN(tt.temp)) {
+				t.Errorf("expected an internal temperature of %v, got %v", tt.temp, polled.system.Temp)
+			}
+		})
+	}
+}
+
+func TestRepairRawstatusWithoutSystem(t *testing.T) {
+	if _, _, err := repairRawstatus([]byte(`{"syst`)); err == nil {
+		t.Error("expected a response without a system object to be unrepairable")
+	}
+}
+
+func TestRepairedSystemMustBeAnObject(t *testing.T) {
+	for _, data := range []string{
+		`{"system":null,"output1":{"outputnickname":"Heat"}}`,
+		`{"system":NaN,"output1":{"outputnickname":"Heat"}}`,
+		`{"system":95,"output1":{"outputnickname":"Heat"}}`,
+	} {
+		repaired, _, err := repairRawstatus([]byte(data))
+		if err != nil {
+			t.Fatalf("unable to repair %s: %s", data, err)
+		}
+
+		if err := json.Unmarshal(repaired, newInfo()); err == nil {
+			t.Errorf("expected %s to be rejected without a system", repaired)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"output1":{"outputnickname":"Heat"}}`), newInfo()); err == nil {
+		t.Error("expected a response without a system to be rejected")
+	}
+}
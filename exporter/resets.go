@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// checkPowerResets compares the device's power reset counter between two successful polls. The device doesn't say
+// when it was reset, so the time that we noticed is used instead, which will be at most one poll interval late.
+func (h *herpstat) checkPowerResets(previous, polled *info, at time.Time) {
+	before, after := previous.system.PowerResets, polled.system.PowerResets
+
+	switch {
+	case after > before:
+		level.Warn(logger).Log(
+			"msg", "Power reset detected",
+			"event", "power_reset",
+			"address", h.device.Address,
+			"system", polled.system.Name,
+			"previous", before,
+			"current", after,
+			"resets", after-before,
+		)
+
+		h.Lock()
+		h.lastReset = at
+		h.Unlock()
+	case after < before:
+		level.Warn(logger).Log(
+			"msg", "Power reset counter went backwards. Was the device factory reset?",
+			"event", "power_reset_counter_reset",
+			"address", h.device.Address,
+			"system", polled.system.Name,
+			"previous", before,
+			"current", after,
+		)
+	}
+}
+
+// lastResetTime returns when we last saw the device's power reset counter go up, or zero if we haven't
+func (h *herpstat) lastResetTime() time.Time {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.lastReset
+}
@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPowerResets(t *testing.T) {
+	device := simulator.New(simulator.Config{Outputs: 1})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	// resets from before the exporter started don't count as being seen
+	device.PowerReset()
+
+	e := newExporter(newDeviceConfig(strings.TrimPrefix(server.URL, "http://")))
+
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll the simulator")
+	}
+
+	if !e.herpstat.lastResetTime().IsZero() {
+		t.Error("expected no reset to have been seen yet")
+	}
+
+	if got := testutil.CollectAndCount(e, "herpstat_system_last_reset_timestamp_seconds"); got != 0 {
+		t.Errorf("expected no last reset timestamp, got %d series", got)
+	}
+
+	before := time.Now()
+
+	device.PowerReset()
+
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll the simulator")
+	}
+
+	if lastReset := e.herpstat.lastResetTime(); lastReset.Before(before) {
+		t.Errorf("expected the reset to be seen after %s, got %s", before, lastReset)
+	}
+
+	expected := `
+# HELP herpstat_system_reset_total Number of times Herpstat has reset, according to the Herpstat itself.
+# TYPE herpstat_system_reset_total counter
+herpstat_system_reset_total{system="Herpstat Simulator"} 2
+`
+
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected), "herpstat_system_reset_total"); err != nil {
+		t.Error(err)
+	}
+
+	if got := testutil.CollectAndCount(e, "herpstat_system_last_reset_timestamp_seconds"); got != 1 {
+		t.Errorf("expected a last reset timestamp, got %d series", got)
+	}
+}
@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// safetyRelayState is a parsed version of the system's free-form "safetyrelay" message
+type safetyRelayState string
+
+const (
+	relayOff             safetyRelayState = "off"
+	relayHighTemperature safetyRelayState = "high_temperature"
+	relayTripped         safetyRelayState = "tripped"
+	relayUnknown         safetyRelayState = "unknown"
+
+	// safetyRelayTripsPath is where the recent trip history is served
+	safetyRelayTripsPath = "/api/v1/safetyrelay/trips"
+
+	// how many trips are kept in the history, across every device
+	maxSafetyRelayTrips = 100
+)
+
+// safetyRelayStates is every state in the order they're exported in the herpstat_system_safetyrelay_state state set
+var safetyRelayStates = []safetyRelayState{relayOff, relayHighTemperature, relayTripped, relayUnknown}
+
+// parseSafetyRelay turns the device's safety relay message (eg: "ON (HIGH TEMPERATURE SHUTOFF)") into a
+// [exporter.safetyRelayState]. Any other "ON" message counts as a generic trip.
+func parseSafetyRelay(raw string) safetyRelayState {
+	relay := strings.ToUpper(strings.TrimSpace(raw))
+
+	switch {
+	case raw == safetyRelayOff, strings.HasPrefix(relay, "OFF"):
+		return relayOff
+	case strings.HasPrefix(relay, "ON") && strings.Contains(relay, "HIGH TEMP"):
+		return relayHighTemperature
+	case strings.HasPrefix(relay, "ON"):
+		return relayTripped
+	default:
+		return relayUnknown
+	}
+}
+
+// isTripped checks whether the safety relay has cut power to the outputs
+func (s safetyRelayState) isTripped() bool {
+	return s == relayHighTemperature || s == relayTripped
+}
+
+// safetyRelayTrip is a single time that a device's safety relay was seen going from off to tripped
+type safetyRelayTrip struct {
+	System    string           `json:"system"`
+	Address   string           `json:"address"`
+	State     safetyRelayState `json:"state"`
+	Message   string           `json:"message"`
+	TrippedAt time.Time        `json:"tripped_at"`
+	ClearedAt *time.Time       `json:"cleared_at,omitempty"`
+}
+
+// safetyRelayTrips keeps the most recent trips from every device, oldest first
+type safetyRelayTrips struct {
+	sync.Mutex
+
+	trips []*safetyRelayTrip
+}
+
+func newSafetyRelayTrips() *safetyRelayTrips {
+	return &safetyRelayTrips{}
+}
+
+// tripped records a new trip, dropping the oldest one once there are too many
+func (t *safetyRelayTrips) tripped(trip *safetyRelayTrip) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.trips = append(t.trips, trip)
+
+	if len(t.trips) > maxSafetyRelayTrips {
+		t.trips = t.trips[len(t.trips)-maxSafetyRelayTrips:]
+	}
+}
+
+// cleared marks a device's most recent trip as over
+func (t *safetyRelayTrips) cleared(address string, at time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	for i := len(t.trips) - 1; i >= 0; i-- {
+		if t.trips[i].Address != address {
+			continue
+		}
+
+		if t.trips[i].ClearedAt == nil {
+			t.trips[i].ClearedAt = &at
+		}
+
+		return
+	}
+}
+
+// list returns a copy of the history, newest first
+func (t *safetyRelayTrips) list() []safetyRelayTrip {
+	t.Lock()
+	defer t.Unlock()
+
+	trips := make([]safetyRelayTrip, 0, len(t.trips))
+	for i := len(t.trips) - 1; i >= 0; i-- {
+		trips = append(trips, *t.trips[i])
+	}
+
+	return trips
+}
+
+// handler serves the trip history as JSON
+func (t *safetyRelayTrips) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"trips": t.list()})
+	}
+}
+
+// checkSafetyRelay compares the safety relay between two successful polls, counting and recording any trip
+func (h *herpstat) checkSafetyRelay(previous, polled *info, at time.Time) {
+	before := parseSafetyRelay(previous.system.SafetyRelay)
+	after := parseSafetyRelay(polled.system.SafetyRelay)
+
+	switch {
+	case !before.isTripped() && after.isTripped():
+		level.Warn(logger).Log("msg", "Safety relay tripped", "address", h.device.Address, "system", polled.system.Name, "relay", polled.system.SafetyRelay)
+
+		h.Lock()
+		h.relayTrips++
+		h.Unlock()
+
+		if h.trips != nil {
+			h.trips.tripped(&safetyRelayTrip{
+				System:    polled.system.Name,
+				Address:   h.device.Address,
+				State:     after,
+				Message:   polled.system.SafetyRelay,
+				TrippedAt: at,
+			})
+		}
+	case before.isTripped() && !after.isTripped():
+		level.Info(logger).Log("msg", "Safety relay cleared", "address", h.device.Address, "system", polled.system.Name, "relay", polled.system.SafetyRelay)
+
+		if h.trips != nil {
+			h.trips.cleared(h.device.Address, at)
+		}
+	}
+}
+
+// safetyRelayTripCount returns how many times we've seen the safety relay trip since the exporter started
+func (h *herpstat) safetyRelayTripCount() float64 {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.relayTrips
+}
@@ -0,0 +1,98 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jjack/herpstat_spyderweb_exporter/simulator"
+)
+
+func TestParseSafetyRelay(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want safetyRelayState
+	}{
+		{"OFF (NORMAL OPERATION)", relayOff},
+		{"Off", relayOff},
+		{"ON (HIGH TEMPERATURE SHUTOFF)", relayHighTemperature},
+		{"ON (EXTERNAL SHUTOFF)", relayTripped},
+		{"", relayUnknown},
+		{"???", relayUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := parseSafetyRelay(tt.raw); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.raw, tt.want, got)
+		}
+	}
+}
+
+func TestSafetyRelayTrips(t *testing.T) {
+	device := simulator.New(simulator.Config{Outputs: 1})
+	server := httptest.NewServer(device)
+	t.Cleanup(server.Close)
+
+	// a relay that was already tripped before we started watching doesn't count
+	device.TripSafetyRelay("")
+
+	e := newExporter(newDeviceConfig(strings.TrimPrefix(server.URL, "http://")))
+	e.herpstat.trips = newSafetyRelayTrips()
+
+	steps := []struct {
+		trip  bool
+		trips float64
+	}{
+		{true, 0},
+		{false, 0},
+		{true, 1},
+		{true, 1},
+		{false, 1},
+		{true, 2},
+	}
+
+	for i, step := range steps {
+		if step.trip {
+			device.TripSafetyRelay("")
+		} else {
+			device.ResetSafetyRelay()
+		}
+
+		if !e.herpstat.refresh() {
+			t.Fatal("unable to poll the simulator")
+		}
+
+		if got := e.herpstat.safetyRelayTripCount(); got != step.trips {
+			t.Errorf("poll %d: expected %v trips, got %v", i+1, step.trips, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.herpstat.trips.handler()(rec, httptest.NewRequest(http.MethodGet, safetyRelayTripsPath, http.NoBody))
+
+	var body struct {
+		Trips []safetyRelayTrip `json:"trips"`
+	}
+
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("unable to decode trips: %s", err)
+	}
+
+	if len(body.Trips) != 2 {
+		t.Fatalf("expected 2 trips, got %d", len(body.Trips))
+	}
+
+	if body.Trips[0].ClearedAt != nil {
+		t.Error("expected the newest trip to still be ongoing")
+	}
+
+	if body.Trips[1].ClearedAt == nil {
+		t.Error("expected the oldest trip to be cleared")
+	}
+
+	if body.Trips[1].State != relayHighTemperature || body.Trips[1].Message != simulator.SafetyRelayTripped {
+		t.Errorf("unexpected trip: %+v", body.Trips[1])
+	}
+}
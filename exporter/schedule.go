@@ -0,0 +1,213 @@
+package exporter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// schedulePhase is which part of its day/night schedule an output is in
+type schedulePhase string
+
+const (
+	phaseDay   schedulePhase = "day"
+	phaseNight schedulePhase = "night"
+
+	// how far the probe reading can be from the phase's setpoint while still counting as compliant, unless the
+	// output has a schedule_tolerance in the config file
+	defaultTemperatureTolerance = 1 // Celsius
+	defaultHumidityTolerance    = 5 // %RH
+)
+
+// schedulePhases is every phase in the order they're exported in the herpstat_output_schedule_phase state set
+var schedulePhases = []schedulePhase{phaseDay, phaseNight}
+
+// schedulePhase works out which phase of its day/night schedule an output is in. /RAWSTATUS doesn't say so directly,
+// but the current setpoint matches the day or night setting outside of a ramp, and a ramp's end matches the one it's
+// moving towards. Outputs without distinct day and night settings don't have a schedule, which includes every output
+// on a device that doesn't report the unconfirmed setpoint keys (see [exporter.output.DaySetpoint]).
+func (o *output) schedulePhase() (schedulePhase, bool) {
+	if o.DaySetpoint == nil || o.NightSetpoint == nil || *o.DaySetpoint == *o.NightSetpoint {
+		return "", false
+	}
+
+	if parseRampState(o.Ramping).inSession() {
+		if phase, ok := o.matchPhase(o.RampEnd); ok {
+			return phase, true
+		}
+	}
+
+	if o.Setpoint == nil {
+		return "", false
+	}
+
+	return o.matchPhase(*o.Setpoint)
+}
+
+func (o *output) matchPhase(setting float64) (schedulePhase, bool) {
+	switch setting {
+	case *o.DaySetpoint:
+		return phaseDay, true
+	case *o.NightSetpoint:
+		return phaseNight, true
+	default:
+		return "", false
+	}
+}
+
+// phaseSetpoint is the day or night setting for a phase
+func (o *output) phaseSetpoint(phase schedulePhase) float64 {
+	if phase == phaseDay {
+		return *o.DaySetpoint
+	}
+
+	return *o.NightSetpoint
+}
+
+// outputSchedule is how well an output has kept to its day/night schedule. It's saved to --state.file so that it
+// survives restarts.
+type outputSchedule struct {
+	Phase       schedulePhase                   `json:"phase,omitempty"`
+	InTolerance bool                            `json:"in_tolerance,omitempty"`
+	PhaseSeen   time.Time                       `json:"phase_seen"`
+	Compliance  map[schedulePhase][]usageBucket `json:"compliance,omitempty"`
+}
+
+// scheduleTracker keeps track of how much of the time each of a device's outputs spent within tolerance of its
+// current phase's setpoint, assuming that nothing changed in between polls
+type scheduleTracker struct {
+	sync.Mutex
+
+	device  *deviceConfig
+	outputs map[string]*outputSchedule
+}
+
+func newScheduleTracker(device *deviceConfig) *scheduleTracker {
+	return &scheduleTracker{
+		device:  device,
+		outputs: map[string]*outputSchedule{},
+	}
+}
+
+// tolerance is how far the probe reading can be from the phase's setpoint while still counting as compliant. It's in
+// Celsius for temperature outputs and %RH for humidity outputs.
+func (s *scheduleTracker) tolerance(o *output) float64 {
+	if c, ok := s.device.Outputs[o.ID]; ok && c != nil && c.ScheduleTolerance > 0 {
+		return c.ScheduleTolerance
+	}
+
+	if o.measuresHumidity() {
+		return defaultHumidityTolerance
+	}
+
+	return defaultTemperatureTolerance
+}
+
+// inTolerance checks whether the probe reading is within tolerance of the phase's setpoint
+func (s *scheduleTracker) inTolerance(o *output, phase schedulePhase, reading float64) bool {
+	return math.Abs(o.settingDelta(reading-o.phaseSetpoint(phase))) <= s.tolerance(o)
+}
+
+// update adds the time since the previous poll to the compliance of whichever phase each output was in, assuming
+// that it stayed in (or out of) tolerance in between polls. A poll without a known phase or a believable probe
+// reading means that we don't know what happened until the next one.
+func (s *scheduleTracker) update(info *info, at time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+
+		schedule, ok := s.outputs[o.ID]
+		if !ok {
+			schedule = &outputSchedule{}
+			s.outputs[o.ID] = schedule
+		}
+
+		if elapsed := at.Sub(schedule.PhaseSeen); !schedule.PhaseSeen.IsZero() && elapsed > 0 && elapsed <= maxTrackingGap(s.device) {
+			if schedule.Compliance == nil {
+				schedule.Compliance = map[schedulePhase][]usageBucket{}
+			}
+
+			schedule.Compliance[schedule.Phase] = addToBuckets(schedule.Compliance[schedule.Phase], at, elapsed.Seconds(), boolToFloat(schedule.InTolerance))
+		}
+
+		phase, ok := o.schedulePhase()
+		reading, valid := o.probeReading()
+
+		if !ok || !valid || !o.mode.hasProbe() {
+			schedule.Phase, schedule.InTolerance, schedule.PhaseSeen = "", false, time.Time{}
+			continue
+		}
+
+		schedule.Phase, schedule.InTolerance, schedule.PhaseSeen = phase, s.inTolerance(o, phase, reading), at
+	}
+}
+
+// compliance is how much of the time within the window that the probe reading was within tolerance of the phase's
+// setpoint, out of the time that the output was in that phase
+func (schedule *outputSchedule) compliance(phase schedulePhase, window time.Duration, now time.Time) (float64, bool) {
+	return bucketRatio(schedule.Compliance[phase], window, now)
+}
+
+// status returns a copy of an output's schedule compliance
+func (s *scheduleTracker) status(outputID string) (outputSchedule, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	schedule, ok := s.outputs[outputID]
+	if !ok {
+		return outputSchedule{}, false
+	}
+
+	return *schedule.copy(), true
+}
+
+// copy returns a deep copy of an output's schedule compliance, so that it can be used without holding the lock
+func (schedule *outputSchedule) copy() *outputSchedule {
+	copied := *schedule
+
+	if schedule.Compliance != nil {
+		copied.Compliance = make(map[schedulePhase][]usageBucket, len(schedule.Compliance))
+
+		for phase, buckets := range schedule.Compliance {
+			copied.Compliance[phase] = append([]usageBucket(nil), buckets...)
+		}
+	}
+
+	return &copied
+}
+
+// save returns a copy of every output's schedule compliance, to be written to --state.file
+func (s *scheduleTracker) save() map[string]*outputSchedule {
+	s.Lock()
+	defer s.Unlock()
+
+	saved := make(map[string]*outputSchedule, len(s.outputs))
+
+	for id, schedule := range s.outputs {
+		saved[id] = schedule.copy()
+	}
+
+	return saved
+}
+
+// restore picks up where a previous run left off
+func (s *scheduleTracker) restore(saved map[string]*outputSchedule) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, schedule := range saved {
+		if schedule != nil {
+			s.outputs[id] = schedule
+		}
+	}
+}
+
+func (o *output) phaseLabelValues(systemName *string, phase schedulePhase) []string {
+	return []string{*systemName, o.ID, string(phase)}
+}
+
+func (o *output) phaseWindowLabelValues(systemName *string, phase schedulePhase, window string) []string {
+	return []string{*systemName, o.ID, string(phase), window}
+}
@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchedulePhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		ramping  string
+		setpoint *float64
+		day      *float64
+		night    *float64
+		rampEnd  float64
+		want     schedulePhase
+		ok       bool
+	}{
+		{"day", rampingOff, float64Ptr(90), float64Ptr(90), float64Ptr(75), 0, phaseDay, true},
+		{"night", rampingOff, float64Ptr(75), float64Ptr(90), float64Ptr(75), 0, phaseNight, true},
+		{"ramping to night", "Ramping Down", float64Ptr(82), float64Ptr(90), float64Ptr(75), 75, phaseNight, true},
+		{"manual setpoint", rampingOff, float64Ptr(80), float64Ptr(90), float64Ptr(75), 0, "", false},
+		{"same day and night", rampingOff, float64Ptr(90), float64Ptr(90), float64Ptr(90), 0, "", false},
+		{"no schedule", rampingOff, float64Ptr(90), nil, nil, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		o := &output{Ramping: tt.ramping, Setpoint: tt.setpoint, DaySetpoint: tt.day, NightSetpoint: tt.night, RampEnd: tt.rampEnd}
+
+		if got, ok := o.schedulePhase(); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected %q (%v), got %q (%v)", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestScheduleCompliance(t *testing.T) {
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = 10 * time.Minute
+
+	s := newScheduleTracker(device)
+	start := time.Now().Truncate(time.Hour)
+
+	steps := []struct {
+		after    time.Duration
+		setpoint float64
+		reading  float64
+	}{
+		// day: 10 minutes within 1°C and 10 minutes outside of it
+		{0, 90, 91},
+		{10 * time.Minute, 90, 85},
+		// night: 10 minutes within 1°C
+		{20 * time.Minute, 75, 75},
+		{30 * time.Minute, 75, 75},
+	}
+
+	for _, step := range steps {
+		s.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: step.reading, Setpoint: float64Ptr(step.setpoint), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start.Add(step.after))
+	}
+
+	schedule, _ := s.status("1")
+	now := start.Add(30 * time.Minute)
+
+	if ratio, ok := schedule.compliance(phaseDay, time.Hour, now); !ok || math.Abs(ratio-0.5) > 1e-9 {
+		t.Errorf("expected day compliance of 0.5, got %v (%v)", ratio, ok)
+	}
+
+	if ratio, ok := schedule.compliance(phaseNight, time.Hour, now); !ok || ratio != 1 {
+		t.Errorf("expected night compliance of 1, got %v (%v)", ratio, ok)
+	}
+
+	// a wider tolerance makes 5°F off count
+	device.Outputs = map[string]*outputConfig{"1": {ScheduleTolerance: 3}}
+
+	s = newScheduleTracker(device)
+	s.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: 85, Setpoint: float64Ptr(90), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start)
+	s.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: 85, Setpoint: float64Ptr(90), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start.Add(time.Minute))
+
+	schedule, _ = s.status("1")
+	if ratio, ok := schedule.compliance(phaseDay, time.Hour, start.Add(time.Minute)); !ok || ratio != 1 {
+		t.Errorf("expected day compliance of 1 with a wider tolerance, got %v (%v)", ratio, ok)
+	}
+}
+
+func TestScheduleState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = 10 * time.Minute
+
+	start := time.Now().Truncate(time.Hour)
+
+	s := newScheduleTracker(device)
+	s.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: 90, Setpoint: float64Ptr(90), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start)
+	s.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: 90, Setpoint: float64Ptr(90), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start.Add(time.Minute))
+
+	store := newStateStore(path)
+	store.register(fixtureAddress, newUsageTracker(device), s)
+
+	if err := store.save(); err != nil {
+		t.Fatalf("unable to save state: %v", err)
+	}
+
+	// schedule compliance has its own section, separate from energy and duty cycle
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read state: %v", err)
+	}
+
+	saved := savedState{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("unable to parse state: %v", err)
+	}
+
+	if _, ok := saved.Schedule[fixtureAddress]["1"]; !ok {
+		t.Errorf("expected output 1 in the schedule section, got %s", data)
+	}
+
+	restored := newScheduleTracker(device)
+
+	store = newStateStore(path)
+	if err := store.load(); err != nil {
+		t.Fatalf("unable to load state: %v", err)
+	}
+
+	store.register(fixtureAddress, newUsageTracker(device), restored)
+
+	// carry on from where the last run left off
+	restored.update(newTestInfo(system{Name: "Schedule"}, output{mode: modeHeating, unit: unitFahrenheit, Ramping: rampingOff, ProbeTemp: 80, Setpoint: float64Ptr(90), DaySetpoint: float64Ptr(90), NightSetpoint: float64Ptr(75)}), start.Add(2*time.Minute))
+
+	schedule, _ := restored.status("1")
+	if ratio, ok := schedule.compliance(phaseDay, time.Hour, start.Add(2*time.Minute)); !ok || ratio != 1 {
+		t.Errorf("expected day compliance of 1 after restoring, got %v (%v)", ratio, ok)
+	}
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	stateVersion      = 1
+	stateSaveInterval = time.Minute
+)
+
+// savedState is what's written to --state.file
+type savedState struct {
+	Version  int                                   `json:"version"`
+	Usage    map[string]map[string]*outputUsage    `json:"usage"`
+	Schedule map[string]map[string]*outputSchedule `json:"schedule,omitempty"`
+}
+
+// stateStore keeps anything that needs to survive a restart in --state.file, which is saved every
+// [exporter.stateSaveInterval]. Devices are keyed by their address.
+type stateStore struct {
+	sync.Mutex
+
+	path      string
+	loaded    savedState
+	trackers  map[string]*usageTracker
+	schedules map[string]*scheduleTracker
+}
+
+func newSavedState() savedState {
+	return savedState{
+		Version:  stateVersion,
+		Usage:    map[string]map[string]*outputUsage{},
+		Schedule: map[string]map[string]*outputSchedule{},
+	}
+}
+
+func newStateStore(path string) *stateStore {
+	return &stateStore{
+		path:      path,
+		loaded:    newSavedState(),
+		trackers:  map[string]*usageTracker{},
+		schedules: map[string]*scheduleTracker{},
+	}
+}
+
+// load reads the state file, if there is one
+func (s *stateStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		level.Info(logger).Log("msg", "No state file yet. Starting fresh.", "path", s.path)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to read state file: %w", err)
+	}
+
+	loaded := savedState{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("unable to parse state file: %w", err)
+	}
+
+	if loaded.Version != stateVersion {
+		return fmt.Errorf("state file is version %d, but only version %d is supported", loaded.Version, stateVersion)
+	}
+
+	if loaded.Usage != nil {
+		s.loaded.Usage = loaded.Usage
+	}
+
+	if loaded.Schedule != nil {
+		s.loaded.Schedule = loaded.Schedule
+	}
+
+	return nil
+}
+
+// register restores a device's usage and schedule compliance from the state file and makes sure they're included
+// whenever it's saved
+func (s *stateStore) register(address string, u *usageTracker, schedule *scheduleTracker) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.trackers[address] = u
+	s.schedules[address] = schedule
+
+	if saved, ok := s.loaded.Usage[address]; ok {
+		u.restore(saved)
+	}
+
+	if saved, ok := s.loaded.Schedule[address]; ok {
+		schedule.restore(saved)
+	}
+}
+
+// save writes the state file. It's written to a temporary file first, so that a crash can't leave it half written.
+func (s *stateStore) save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	state := newSavedState()
+
+	// keep devices that aren't configured anymore, in case they come back
+	for address, usage := range s.loaded.Usage {
+		state.Usage[address] = usage
+	}
+
+	for address, schedule := range s.loaded.Schedule {
+		state.Schedule[address] = schedule
+	}
+
+	for address, u := range s.trackers {
+		state.Usage[address] = u.save()
+	}
+
+	for address, schedule := range s.schedules {
+		state.Schedule[address] = schedule.save()
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create state file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck // it's already been renamed if everything went well
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write state file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// run saves the state file every [exporter.stateSaveInterval] until the context is cancelled, and one last time
+// after that so that nothing since the last save is lost on shutdown
+func (s *stateStore) run(ctx context.Context) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+
+		if err := s.save(); err != nil {
+			level.Error(logger).Log("msg", "unable to save state", "err", err)
+		}
+	}
+}
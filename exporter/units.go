@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+)
+
+// temperatureUnit is the scale that a Herpstat SpyderWeb reports its temperatures in. Readings are converted to
+// Celsius before they're exported as *_celsius metrics, following Prometheus' base unit conventions.
+type temperatureUnit string
+
+const (
+	unitAuto       temperatureUnit = "auto"
+	unitFahrenheit temperatureUnit = "fahrenheit"
+	unitCelsius    temperatureUnit = "celsius"
+
+	// unitUnknown is used until auto-detection has something to go on. Temperatures can't be converted, so they're
+	// treated as invalid.
+	unitUnknown temperatureUnit = "unknown"
+
+	// a SpyderWeb's internal temperature sits somewhere around room temperature or a bit warmer. anything at or
+	// above autoDetectFahrenheitMin would be far too hot in Celsius, and anything at or below autoDetectCelsiusMax
+	// would be near freezing in Fahrenheit. in between, it could be either.
+	autoDetectCelsiusMax    = 45
+	autoDetectFahrenheitMin = 65
+)
+
+// parseTemperatureUnit validates a unit from either --herpstat.temperature-unit or the config file
+func parseTemperatureUnit(unit string) (temperatureUnit, error) {
+	switch u := temperatureUnit(unit); u {
+	case unitAuto, unitFahrenheit, unitCelsius:
+		return u, nil
+	case "":
+		return unitAuto, nil
+	default:
+		return "", fmt.Errorf("unknown temperature unit %q. it must be one of %s, %s or %s", unit, unitAuto, unitFahrenheit, unitCelsius)
+	}
+}
+
+// detectTemperatureUnit works out which scale a device is using based on its internal temperature, unless the unit
+// was given explicitly. Once an internal temperature could only have been in one unit, the device is latched to it,
+// since a Fahrenheit device in a cold room can easily drop into the range where it could be either. Until then,
+// including polls without a usable internal temperature, the unit is unknown.
+func detectTemperatureUnit(configured, latched temperatureUnit, s *system) (unit temperatureUnit, latch bool) {
+	switch {
+	case configured != unitAuto:
+		return configured, false
+	case latched != "":
+		return latched, false
+	case math.IsNaN(s.Temp) || s.Temp <= 0:
+		return unitUnknown, false
+	case s.Temp >= autoDetectFahrenheitMin:
+		return unitFahrenheit, true
+	case s.Temp <= autoDetectCelsiusMax:
+		return unitCelsius, true
+	default:
+		return unitUnknown, false
+	}
+}
+
+// known checks whether temperatures in this unit can be converted to Celsius
+func (u temperatureUnit) known() bool {
+	return u == unitFahrenheit || u == unitCelsius
+}
+
+// toCelsius converts a temperature reading from this unit into Celsius
+func (u temperatureUnit) toCelsius(value float64) float64 {
+	if u == unitFahrenheit {
+		return (value - 32) * 5 / 9
+	}
+
+	return value
+}
+
+// deltaToCelsius converts the difference between two temperatures from this unit into Celsius
+func (u temperatureUnit) deltaToCelsius(value float64) float64 {
+	if u == unitFahrenheit {
+		return value * 5 / 9
+	}
+
+	return value
+}
+
+// setTemperatureUnit records which unit the system and all of its outputs are reporting in
+func (info *info) setTemperatureUnit(unit temperatureUnit) {
+	info.system.unit = unit
+
+	for i := range *info.outputs {
+		(*info.outputs)[i].unit = unit
+	}
+}
+
+// hasKnownUnit checks whether an output's readings and settings can be converted with [exporter.output.setting]
+func (o *output) hasKnownUnit() bool {
+	return o.measuresHumidity() || o.unit.known()
+}
+
+// setting converts one of an output's settings (alarms, ramp end, etc) to Celsius, but only if the output is
+// controlling temperature. Humidity outputs' settings are left as a percentage.
+func (o *output) setting(value float64) float64 {
+	if o.measuresHumidity() {
+		return value
+	}
+
+	return o.unit.toCelsius(value)
+}
+
+// settingDelta converts the difference between a reading and one of an output's settings to Celsius, but only if
+// the output is controlling temperature
+func (o *output) settingDelta(value float64) float64 {
+	if o.measuresHumidity() {
+		return value
+	}
+
+	return o.unit.deltaToCelsius(value)
+}
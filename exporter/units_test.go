@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDetectTemperatureUnit(t *testing.T) {
+	tests := []struct {
+		configured temperatureUnit
+		latched    temperatureUnit
+		temp       float64
+		want       temperatureUnit
+		latch      bool
+	}{
+		{unitAuto, "", 95, unitFahrenheit, true},
+		{unitAuto, "", 65, unitFahrenheit, true},
+		{unitAuto, "", 31, unitCelsius, true},
+		{unitAuto, "", 45, unitCelsius, true},
+		// too close to call either way
+		{unitAuto, "", 58, unitUnknown, false},
+		{unitAuto, "", 0, unitUnknown, false},
+		{unitAuto, "", math.NaN(), unitUnknown, false},
+		// a Fahrenheit device in a cold room stays in Fahrenheit once it has been detected
+		{unitAuto, unitFahrenheit, 58, unitFahrenheit, false},
+		{unitAuto, unitFahrenheit, 31, unitFahrenheit, false},
+		{unitAuto, unitCelsius, math.NaN(), unitCelsius, false},
+		{unitFahrenheit, "", 31, unitFahrenheit, false},
+		{unitCelsius, "", 95, unitCelsius, false},
+	}
+
+	for _, tt := range tests {
+		unit, latch := detectTemperatureUnit(tt.configured, tt.latched, &system{Temp: tt.temp})
+
+		if unit != tt.want || latch != tt.latch {
+			t.Errorf("%s/%q at %v: expected %s (latch: %v), got %s (latch: %v)", tt.configured, tt.latched, tt.temp, tt.want, tt.latch, unit, latch)
+		}
+	}
+}
+
+func TestTemperatureUnitLatches(t *testing.T) {
+	e := newExporter(newDeviceConfig(fixtureAddress))
+
+	poll := func(rawstatus string) *info {
+		t.Helper()
+
+		e.herpstat.client.Transport = fixtureTransport(rawstatus)
+
+		if !e.herpstat.refresh() {
+			t.Fatal("unable to poll")
+		}
+
+		info, _, _ := e.herpstat.snapshot()
+
+		return info
+	}
+
+	// the internal temperature could be either unit, so the probe reading can't be converted
+	info := poll(`{"system":{"numberofoutputs":1,"internaltemp":58},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`)
+
+	if _, ok := (*info.outputs)[0].probeReading(); ok || info.system.unit != unitUnknown {
+		t.Errorf("expected the unit to be unknown, got %s", info.system.unit)
+	}
+
+	// until it clearly can't be Celsius
+	info = poll(`{"system":{"numberofoutputs":1,"internaltemp":75},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`)
+
+	if reading, ok := (*info.outputs)[0].probeReading(); !ok || info.system.unit != unitFahrenheit || (*info.outputs)[0].unit.toCelsius(reading) != 30 {
+		t.Errorf("expected 86°F, got %v (valid: %v) in %s", reading, ok, info.system.unit)
+	}
+
+	// from then on, cooling off or losing the internal temperature doesn't flip it to Celsius
+	for _, rawstatus := range []string{
+		`{"system":{"numberofoutputs":1,"internaltemp":58},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`,
+		`{"system":{"numberofoutputs":1},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86}}`,
+	} {
+		if info := poll(rawstatus); info.system.unit != unitFahrenheit {
+			t.Errorf("expected the unit to stay Fahrenheit, got %s for %s", info.system.unit, rawstatus)
+		}
+	}
+}
+
+func TestUnknownUnitWithholdsSettings(t *testing.T) {
+	e := newExporter(newDeviceConfig(fixtureAddress))
+	e.herpstat.client.Transport = fixtureTransport(`{"system":{"numberofoutputs":1,"internaltemp":58},"output1":{"outputmode":"Proportional Heating","probereadingTEMP":86,"currentsetting":90,"highalarm":95,"lowalarm":80,"endoframpsetting":90}}`)
+
+	if !e.herpstat.refresh() {
+		t.Fatal("unable to poll")
+	}
+
+	// there's no telling whether 90 is 90°C or 32.2°C
+	for _, name := range []string{"herpstat_output_setpoint", "herpstat_output_alarm_high", "herpstat_output_alarm_low", "herpstat_output_ramp_end"} {
+		if n := testutil.CollectAndCount(e, name); n != 0 {
+			t.Errorf("expected %s not to be exported without a unit, got %d", name, n)
+		}
+	}
+}
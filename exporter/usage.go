@@ -0,0 +1,241 @@
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// usage is kept in one minute buckets, for up to the longest duty cycle window
+	usageBucketSize = time.Minute
+	usageRetention  = 24 * time.Hour
+
+	// how many poll intervals can go by before we stop assuming that an output stayed at the same power level
+	maxUsageGapPolls = 5
+
+	// power levels are a percentage
+	maxPowerPercent = 100
+)
+
+// dutyCycleWindows are the windows that herpstat_output_duty_cycle_ratio is exported for, keyed by the "window" label
+var dutyCycleWindows = []struct {
+	label  string
+	window time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+// outputConfig holds anything that the device can't tell us about one of its outputs
+//
+//	outputs:
+//	  "1":
+//	    wattage: 150
+//	    schedule_tolerance: 1
+type outputConfig struct {
+	Wattage           float64 `yaml:"wattage,omitempty"`
+	ScheduleTolerance float64 `yaml:"schedule_tolerance,omitempty"`
+}
+
+// validateOutputConfigs checks that each output's settings make sense
+func validateOutputConfigs(outputs map[string]*outputConfig) error {
+	for id, o := range outputs {
+		if o == nil {
+			return fmt.Errorf("output %s is empty", id)
+		}
+
+		if o.Wattage < 0 {
+			return fmt.Errorf("output %s has a negative wattage", id)
+		}
+
+		if o.ScheduleTolerance < 0 {
+			return fmt.Errorf("output %s has a negative schedule tolerance", id)
+		}
+	}
+
+	return nil
+}
+
+// outputUsage is how much an output has been used. It's saved to --state.file so that it survives restarts.
+type outputUsage struct {
+	EnergyJoules float64       `json:"energy_joules"`
+	LastPower    float64       `json:"last_power"`
+	LastSeen     time.Time     `json:"last_seen"`
+	Buckets      []usageBucket `json:"buckets"`
+}
+
+// usageBucket is how long an output was watched for during a single minute, and how much of that time it was on for,
+// weighted by its power level
+type usageBucket struct {
+	Start    int64   `json:"start"`
+	Observed float64 `json:"observed"`
+	On       float64 `json:"on"`
+}
+
+// usageTracker integrates each of a device's outputs' power levels over time, assuming that they stay at the same
+// level in between polls
+type usageTracker struct {
+	sync.Mutex
+
+	device  *deviceConfig
+	outputs map[string]*outputUsage
+}
+
+func newUsageTracker(device *deviceConfig) *usageTracker {
+	return &usageTracker{
+		device:  device,
+		outputs: map[string]*outputUsage{},
+	}
+}
+
+// wattage returns the configured load on an output, or 0 if there isn't one
+func (u *usageTracker) wattage(outputID string) float64 {
+	if o, ok := u.device.Outputs[outputID]; ok && o != nil {
+		return o.Wattage
+	}
+
+	return 0
+}
+
+// maxTrackingGap is how long we're willing to assume that an output stayed the same in between polls
+func maxTrackingGap(device *deviceConfig) time.Duration {
+	if device.MaxStaleness > 0 {
+		return device.MaxStaleness
+	}
+
+	return maxUsageGapPolls * device.PollInterval
+}
+
+// update adds the time since the previous poll to each output's usage
+func (u *usageTracker) update(info *info, at time.Time) {
+	u.Lock()
+	defer u.Unlock()
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+
+		usage, ok := u.outputs[o.ID]
+		if !ok {
+			usage = &outputUsage{}
+			u.outputs[o.ID] = usage
+		}
+
+		// a reading that we don't believe means that we don't know what the output was doing until the next one
+		if !o.invalid.valid(fieldPower) {
+			usage.LastSeen = time.Time{}
+			continue
+		}
+
+		if elapsed := at.Sub(usage.LastSeen); !usage.LastSeen.IsZero() && elapsed > 0 && elapsed <= maxTrackingGap(u.device) {
+			ratio := usage.LastPower / maxPowerPercent
+
+			usage.EnergyJoules += u.wattage(o.ID) * ratio * elapsed.Seconds()
+			usage.add(at, elapsed.Seconds(), ratio)
+		}
+
+		usage.LastPower = o.Power
+		usage.LastSeen = at
+	}
+}
+
+// add records some time in the bucket for the given time
+func (usage *outputUsage) add(at time.Time, observed, ratio float64) {
+	usage.Buckets = addToBuckets(usage.Buckets, at, observed, ratio)
+}
+
+// dutyCycle is how much of the time within the window that the output was on, weighted by its power level. Time
+// that the output wasn't being watched for doesn't count either way.
+func (usage *outputUsage) dutyCycle(window time.Duration, now time.Time) (float64, bool) {
+	return bucketRatio(usage.Buckets, window, now)
+}
+
+// addToBuckets records some time in the bucket for the given time, dropping any buckets that are too old to matter
+func addToBuckets(buckets []usageBucket, at time.Time, observed, ratio float64) []usageBucket {
+	start := at.Truncate(usageBucketSize).Unix()
+
+	if n := len(buckets); n > 0 && buckets[n-1].Start == start {
+		buckets[n-1].Observed += observed
+		buckets[n-1].On += observed * ratio
+	} else {
+		buckets = append(buckets, usageBucket{Start: start, Observed: observed, On: observed * ratio})
+	}
+
+	oldest := at.Add(-usageRetention).Unix()
+	for len(buckets) > 0 && buckets[0].Start < oldest {
+		buckets = buckets[1:]
+	}
+
+	return buckets
+}
+
+// bucketRatio is how much of the observed time within the window was "on"
+func bucketRatio(buckets []usageBucket, window time.Duration, now time.Time) (float64, bool) {
+	oldest := now.Add(-window).Unix()
+
+	var observed, on float64
+
+	for _, b := range buckets {
+		if b.Start >= oldest {
+			observed += b.Observed
+			on += b.On
+		}
+	}
+
+	if observed == 0 {
+		return 0, false
+	}
+
+	return on / observed, true
+}
+
+// status returns a copy of an output's usage
+func (u *usageTracker) status(outputID string) (outputUsage, bool) {
+	u.Lock()
+	defer u.Unlock()
+
+	usage, ok := u.outputs[outputID]
+	if !ok {
+		return outputUsage{}, false
+	}
+
+	return *usage.copy(), true
+}
+
+// copy returns a deep copy of an output's usage, so that it can be used without holding the lock
+func (usage *outputUsage) copy() *outputUsage {
+	copied := *usage
+	copied.Buckets = append([]usageBucket(nil), usage.Buckets...)
+
+	return &copied
+}
+
+// save returns a copy of every output's usage, to be written to --state.file
+func (u *usageTracker) save() map[string]*outputUsage {
+	u.Lock()
+	defer u.Unlock()
+
+	saved := make(map[string]*outputUsage, len(u.outputs))
+
+	for id, usage := range u.outputs {
+		saved[id] = usage.copy()
+	}
+
+	return saved
+}
+
+// restore picks up where a previous run left off
+func (u *usageTracker) restore(saved map[string]*outputUsage) {
+	u.Lock()
+	defer u.Unlock()
+
+	for id, usage := range saved {
+		if usage != nil {
+			u.outputs[id] = usage
+		}
+	}
+}
+
+func (o *output) windowLabelValues(systemName *string, window string) []string {
+	return []string{*systemName, o.ID, window}
+}
@@ -0,0 +1,157 @@
+package exporter
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker(t *testing.T) {
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = 10 * time.Minute
+	device.Outputs = map[string]*outputConfig{"1": {Wattage: 100}}
+
+	u := newUsageTracker(device)
+	start := time.Now().Truncate(time.Hour)
+
+	steps := []struct {
+		after   time.Duration
+		power   float64
+		invalid fieldSet
+	}{
+		// 10 minutes at 100% and 10 minutes at 50%
+		{0, 100, nil},
+		{10 * time.Minute, 50, nil},
+		{20 * time.Minute, 0, nil},
+		// too long of a gap to count
+		{time.Hour, 100, nil},
+		// an invalid reading means that we don't know what happened until the next one
+		{61 * time.Minute, 100, fieldSet{fieldPower: true}},
+		{62 * time.Minute, 0, nil},
+	}
+
+	for _, step := range steps {
+		u.update(newTestInfo(system{Name: "Usage"}, output{Power: step.power, invalid: step.invalid}), start.Add(step.after))
+	}
+
+	usage, ok := u.status("1")
+	if !ok {
+		t.Fatal("expected usage for output 1")
+	}
+
+	if want := 100.0 * (600 + 300); math.Abs(usage.EnergyJoules-want) > 1e-6 {
+		t.Errorf("expected %v joules, got %v", want, usage.EnergyJoules)
+	}
+
+	ratio, ok := usage.dutyCycle(time.Hour, start.Add(62*time.Minute))
+	if !ok || math.Abs(ratio-0.75) > 1e-9 {
+		t.Errorf("expected a 1h duty cycle of 0.75, got %v (%v)", ratio, ok)
+	}
+
+	if _, ok := usage.dutyCycle(time.Hour, start.Add(3*time.Hour)); ok {
+		t.Error("expected no 1h duty cycle once the window has passed")
+	}
+
+	if _, ok := u.status("2"); ok {
+		t.Error("expected no usage for an output that was never seen")
+	}
+}
+
+func TestUsageWithoutWattage(t *testing.T) {
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = time.Minute
+
+	u := newUsageTracker(device)
+	start := time.Now()
+
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start)
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start.Add(time.Minute))
+
+	usage, _ := u.status("1")
+	if usage.EnergyJoules != 0 {
+		t.Errorf("expected no energy without a wattage, got %v", usage.EnergyJoules)
+	}
+
+	if ratio, ok := usage.dutyCycle(time.Hour, start.Add(time.Minute)); !ok || ratio != 1 {
+		t.Errorf("expected a duty cycle of 1, got %v (%v)", ratio, ok)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = time.Minute
+	device.Outputs = map[string]*outputConfig{"1": {Wattage: 60}}
+
+	start := time.Now()
+
+	u := newUsageTracker(device)
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start)
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start.Add(time.Minute))
+
+	s := newStateStore(path)
+	if err := s.load(); err != nil {
+		t.Fatalf("expected a missing state file to be fine, got %v", err)
+	}
+
+	s.register(fixtureAddress, u, newScheduleTracker(device))
+
+	if err := s.save(); err != nil {
+		t.Fatalf("unable to save state: %v", err)
+	}
+
+	restored := newUsageTracker(device)
+
+	s = newStateStore(path)
+	if err := s.load(); err != nil {
+		t.Fatalf("unable to load state: %v", err)
+	}
+
+	s.register(fixtureAddress, restored, newScheduleTracker(device))
+
+	// carry on from where the last run left off
+	restored.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start.Add(2*time.Minute))
+
+	usage, ok := restored.status("1")
+	if !ok || usage.EnergyJoules != 60*120 {
+		t.Errorf("expected %v joules after restoring, got %v (%v)", 60*120, usage.EnergyJoules, ok)
+	}
+}
+
+func TestStateStoreSavesOnShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	device := newDeviceConfig(fixtureAddress)
+	device.MaxStaleness = time.Minute
+	device.Outputs = map[string]*outputConfig{"1": {Wattage: 60}}
+
+	start := time.Now()
+
+	u := newUsageTracker(device)
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start)
+	u.update(newTestInfo(system{Name: "Usage"}, output{Power: 100}), start.Add(time.Minute))
+
+	s := newStateStore(path)
+	s.register(fixtureAddress, u, newScheduleTracker(device))
+
+	// long before the first save is due
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.run(ctx)
+
+	restored := newUsageTracker(device)
+
+	s = newStateStore(path)
+	if err := s.load(); err != nil {
+		t.Fatalf("unable to load state: %v", err)
+	}
+
+	s.register(fixtureAddress, restored, newScheduleTracker(device))
+
+	if usage, ok := restored.status("1"); !ok || usage.EnergyJoules != 60*60 {
+		t.Errorf("expected the state to be saved on shutdown, got %+v (%v)", usage, ok)
+	}
+}
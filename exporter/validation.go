@@ -0,0 +1,250 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fields that [exporter.validator] checks, used as the "field" label on herpstat_invalid_readings_total
+const (
+	fieldTemperature         = "temperature"
+	fieldHumidity            = "humidity"
+	fieldInternalTemperature = "internal_temperature"
+	fieldPower               = "power"
+)
+
+// reasons that a reading can be rejected, used as the "reason" label on herpstat_invalid_readings_total
+const (
+	reasonOutOfRange   = "out_of_range"
+	reasonRateOfChange = "rate_of_change"
+)
+
+var (
+	validationFields  = []string{fieldTemperature, fieldHumidity, fieldInternalTemperature, fieldPower}
+	validationReasons = []string{reasonOutOfRange, reasonRateOfChange}
+
+	// if a probe is pulled out in the middle of a poll, we'll get some extremely weird values. these are some
+	// theoretically-typical ranges (eg: you aren't going to heat something above boiling with this). temperatures
+	// are in Celsius.
+	defaultFieldLimits = map[string]fieldLimits{
+		fieldTemperature:         {Min: float64Ptr(-17.78), Max: float64Ptr(100)},
+		fieldHumidity:            {Min: float64Ptr(0), Max: float64Ptr(100)},
+		fieldInternalTemperature: {Min: float64Ptr(-17.78), Max: float64Ptr(100)},
+		fieldPower:               {Min: float64Ptr(0), Max: float64Ptr(100)},
+	}
+)
+
+// validationConfig describes which readings are believable. Fields are checked against their defaults, overridden
+// by anything in Fields, overridden again by anything for that specific output in Outputs. Temperatures are in
+// Celsius, no matter what unit the device reports in.
+//
+//	validation:
+//	  hold_last_good: 3
+//	  fields:
+//	    temperature:
+//	      max_rate: 1
+//	  outputs:
+//	    "1":
+//	      temperature:
+//	        min: 20
+//	        max: 45
+type validationConfig struct {
+	HoldLastGood int                               `yaml:"hold_last_good,omitempty"`
+	Fields       map[string]fieldLimits            `yaml:"fields,omitempty"`
+	Outputs      map[string]map[string]fieldLimits `yaml:"outputs,omitempty"`
+}
+
+// fieldLimits is the range that a field's readings have to be in, and how quickly they're allowed to change (per
+// second). Anything left empty isn't checked.
+type fieldLimits struct {
+	Min     *float64 `yaml:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty"`
+	MaxRate float64  `yaml:"max_rate,omitempty"`
+}
+
+// validate checks that every field is one we know about and that its limits make sense
+func (v *validationConfig) validate() error {
+	if v.HoldLastGood < 0 {
+		return fmt.Errorf("validation hold_last_good can't be negative")
+	}
+
+	if err := validateFieldLimits(v.Fields); err != nil {
+		return err
+	}
+
+	for id, fields := range v.Outputs {
+		if err := validateFieldLimits(fields); err != nil {
+			return fmt.Errorf("output %s: %w", id, err)
+		}
+
+		if _, ok := fields[fieldInternalTemperature]; ok {
+			return fmt.Errorf("output %s: %s isn't an output field", id, fieldInternalTemperature)
+		}
+	}
+
+	return nil
+}
+
+func validateFieldLimits(fields map[string]fieldLimits) error {
+	for field, l := range fields {
+		if _, ok := defaultFieldLimits[field]; !ok {
+			return fmt.Errorf("unknown validation field %q. it must be one of %v", field, validationFields)
+		}
+
+		if l.Min != nil && l.Max != nil && *l.Min > *l.Max {
+			return fmt.Errorf("validation field %s has a min that's above its max", field)
+		}
+
+		if l.MaxRate < 0 {
+			return fmt.Errorf("validation field %s has a negative max_rate", field)
+		}
+	}
+
+	return nil
+}
+
+// merge overrides these limits with anything that's set in the other ones
+func (l fieldLimits) merge(other fieldLimits) fieldLimits {
+	if other.Min != nil {
+		l.Min = other.Min
+	}
+
+	if other.Max != nil {
+		l.Max = other.Max
+	}
+
+	if other.MaxRate != 0 {
+		l.MaxRate = other.MaxRate
+	}
+
+	return l
+}
+
+// check returns why a reading isn't believable, or "" if it is. The rate of change is measured against the last
+// good reading, so a genuine jump is eventually accepted once enough time has gone by.
+func (l fieldLimits) check(value float64, last *goodReading, at time.Time) string {
+	if math.IsNaN(value) || (l.Min != nil && value < *l.Min) || (l.Max != nil && value > *l.Max) {
+		return reasonOutOfRange
+	}
+
+	if l.MaxRate > 0 && last != nil {
+		if elapsed := at.Sub(last.at).Seconds(); elapsed > 0 && math.Abs(value-last.value)/elapsed > l.MaxRate {
+			return reasonRateOfChange
+		}
+	}
+
+	return ""
+}
+
+// goodReading is the last reading of a field that passed validation, both in the device's own unit (raw) and in
+// the unit that its limits are in (value).
+type goodReading struct {
+	raw   float64
+	value float64
+	at    time.Time
+	held  int
+}
+
+// validator checks every poll's readings against [exporter.validationConfig]. Rejected readings are either replaced
+// by the last good one (for up to HoldLastGood polls in a row) or marked as invalid so that they aren't exported.
+// It's only ever used from the polling goroutine.
+type validator struct {
+	config   validationConfig
+	rejected *prometheus.CounterVec
+	last     map[string]*goodReading
+}
+
+func newValidator(config validationConfig, rejected *prometheus.CounterVec) *validator {
+	return &validator{
+		config:   config,
+		rejected: rejected,
+		last:     map[string]*goodReading{},
+	}
+}
+
+// limits returns the limits for a field on the given output. Use an empty ID for system fields.
+func (v *validator) limits(outputID, field string) fieldLimits {
+	l := defaultFieldLimits[field].merge(v.config.Fields[field])
+
+	if outputID != "" {
+		l = l.merge(v.config.Outputs[outputID][field])
+	}
+
+	return l
+}
+
+// validate checks all of the readings in a freshly polled [exporter.info], as of when it was polled
+func (v *validator) validate(info *info, at time.Time) {
+	info.system.invalid = fieldSet{}
+
+	// temperatures in an unknown unit can't be checked against limits in Celsius, let alone exported
+	if info.system.unit.known() {
+		info.system.Temp = v.field(info.system.invalid, "", fieldInternalTemperature, info.system.Temp, info.system.unit.toCelsius, at)
+	} else {
+		info.system.invalid[fieldInternalTemperature] = true
+	}
+
+	for i := range *info.outputs {
+		o := &(*info.outputs)[i]
+		o.invalid = fieldSet{}
+
+		o.Power = v.field(o.invalid, o.ID, fieldPower, o.Power, nil, at)
+
+		switch {
+		case o.mode.hasTemperatureProbe() && o.unit.known():
+			o.ProbeTemp = v.field(o.invalid, o.ID, fieldTemperature, o.ProbeTemp, o.unit.toCelsius, at)
+		case o.mode.hasTemperatureProbe():
+			o.invalid[fieldTemperature] = true
+		}
+
+		if o.mode.hasHumidityProbe() {
+			o.ProbeHumidity = v.field(o.invalid, o.ID, fieldHumidity, o.ProbeHumidity, nil, at)
+		}
+	}
+}
+
+// field validates a single reading, returning either it or the last good reading that's being held in its place.
+// convert turns the reading into the unit that its limits are in, if they're different.
+func (v *validator) field(invalid fieldSet, outputID, field string, raw float64, convert func(float64) float64, at time.Time) float64 {
+	key := outputID + "/" + field
+	last := v.last[key]
+
+	value := raw
+	if convert != nil {
+		value = convert(raw)
+	}
+
+	reason := v.limits(outputID, field).check(value, last, at)
+	if reason == "" {
+		v.last[key] = &goodReading{raw: raw, value: value, at: at}
+		return raw
+	}
+
+	level.Debug(logger).Log("msg", "rejected reading", "output", outputID, "field", field, "reason", reason, "value", raw)
+	v.rejected.WithLabelValues(field, reason).Inc()
+
+	if last != nil && last.held < v.config.HoldLastGood {
+		last.held++
+		return last.raw
+	}
+
+	invalid[field] = true
+
+	return raw
+}
+
+// fieldSet is the set of fields whose readings didn't pass validation
+type fieldSet map[string]bool
+
+// valid checks whether a field's reading passed validation
+func (f fieldSet) valid(field string) bool {
+	return !f[field]
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
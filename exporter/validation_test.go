@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestValidator(c validationConfig) (*validator, *prometheus.CounterVec) {
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"field", "reason"})
+
+	return newValidator(c, rejected), rejected
+}
+
+func TestValidatorRanges(t *testing.T) {
+	v, rejected := newTestValidator(validationConfig{
+		Outputs: map[string]map[string]fieldLimits{"1": {fieldTemperature: {Max: float64Ptr(40)}}},
+	})
+
+	tests := []struct {
+		probeTemp float64
+		valid     bool
+	}{
+		{30, true},
+		{45, false},
+		{-20, false},
+		{3276.7, false},
+	}
+
+	for _, tt := range tests {
+		polled := newTestInfo(system{Temp: 25}, output{mode: modeHeating, unit: unitCelsius, Power: 50, ProbeTemp: tt.probeTemp})
+		v.validate(polled, time.Now())
+
+		if _, ok := (*polled.outputs)[0].probeReading(); ok != tt.valid {
+			t.Errorf("%v: expected valid to be %v, got %v", tt.probeTemp, tt.valid, ok)
+		}
+	}
+
+	if got := testutil.ToFloat64(rejected.WithLabelValues(fieldTemperature, reasonOutOfRange)); got != 3 {
+		t.Errorf("expected 3 out_of_range rejections, got %v", got)
+	}
+}
+
+func TestValidatorRateOfChange(t *testing.T) {
+	v, rejected := newTestValidator(validationConfig{
+		Fields: map[string]fieldLimits{fieldTemperature: {MaxRate: 1}},
+	})
+	start := time.Now()
+
+	for _, tt := range []struct {
+		after     time.Duration
+		probeTemp float64
+		valid     bool
+	}{
+		{0, 30, true},
+		{10 * time.Second, 35, true},
+		{20 * time.Second, 60, false},
+		// a genuine jump is accepted once enough time has passed since the last good reading
+		{40 * time.Second, 60, true},
+	} {
+		polled := newTestInfo(system{Temp: 25}, output{mode: modeHeating, unit: unitCelsius, Power: 50, ProbeTemp: tt.probeTemp})
+		v.validate(polled, start.Add(tt.after))
+
+		if _, ok := (*polled.outputs)[0].probeReading(); ok != tt.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", tt.after, tt.valid, ok)
+		}
+	}
+
+	if got := testutil.ToFloat64(rejected.WithLabelValues(fieldTemperature, reasonRateOfChange)); got != 1 {
+		t.Errorf("expected 1 rate_of_change rejection, got %v", got)
+	}
+}
+
+func TestValidatorHoldLastGood(t *testing.T) {
+	v, _ := newTestValidator(validationConfig{HoldLastGood: 2})
+	start := time.Now()
+
+	for i, tt := range []struct {
+		probeTemp float64
+		want      float64
+		valid     bool
+	}{
+		{30, 30, true},
+		{6553.5, 30, true},
+		{6553.5, 30, true},
+		{6553.5, 6553.5, false},
+		{31, 31, true},
+		{6553.5, 31, true},
+	} {
+		polled := newTestInfo(system{Temp: 25}, output{mode: modeHeating, unit: unitCelsius, Power: 50, ProbeTemp: tt.probeTemp})
+		v.validate(polled, start.Add(time.Duration(i)*10*time.Second))
+
+		reading, ok := (*polled.outputs)[0].probeReading()
+		if ok != tt.valid || reading != tt.want {
+			t.Errorf("poll %d: expected %v (valid: %v), got %v (valid: %v)", i+1, tt.want, tt.valid, reading, ok)
+		}
+	}
+}
+
+func TestValidationConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     validationConfig
+		valid bool
+	}{
+		{"empty", validationConfig{}, true},
+		{"negative hold", validationConfig{HoldLastGood: -1}, false},
+		{"unknown field", validationConfig{Fields: map[string]fieldLimits{"voltage": {}}}, false},
+		{"min above max", validationConfig{Fields: map[string]fieldLimits{fieldHumidity: {Min: float64Ptr(90), Max: float64Ptr(10)}}}, false},
+		{"negative rate", validationConfig{Fields: map[string]fieldLimits{fieldPower: {MaxRate: -1}}}, false},
+		{"system field on an output", validationConfig{Outputs: map[string]map[string]fieldLimits{"1": {fieldInternalTemperature: {}}}}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.c.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
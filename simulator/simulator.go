@@ -0,0 +1,361 @@
+// Package simulator is a fake Herpstat SpyderWeb that serves a realistic /RAWSTATUS page. It can be used as an
+// [http.Handler] in tests via [net/http/httptest], or run on its own via cmd/herpstat-sim for local development.
+//
+// Besides healthy data, it can be scripted to misbehave the same ways that real devices do: drifting temperatures,
+// unplugged probes, invalid JSON, slow responses and safety-relay trips.
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// RawstatusPath is the only page that the simulator serves
+	RawstatusPath = "/RAWSTATUS"
+
+	// SafetyRelayOff is what the device reports when the safety relay hasn't been tripped
+	SafetyRelayOff = "OFF (NORMAL OPERATION)"
+	// SafetyRelayTripped is what the simulator reports when the safety relay is tripped without a message
+	SafetyRelayTripped = "ON (HIGH TEMPERATURE SHUTOFF)"
+
+	// UnpluggedReading is an out of range reading that the simulator reports for an unplugged probe. It isn't taken
+	// from a real device, so the exporter doesn't rely on it to tell that a probe is unplugged.
+	UnpluggedReading = 6553.5
+	// UnpluggedErrorCode is the error code that an output with an unplugged probe reports
+	UnpluggedErrorCode = 1
+	// UnpluggedErrorDesc is the error description that an output with an unplugged probe reports
+	UnpluggedErrorDesc = "Probe Disconnected"
+
+	noErrorDesc = "No Error"
+	rampingOff  = "Not In Session"
+
+	defaultNickname     = "Herpstat Simulator"
+	defaultOutputs      = 4
+	defaultInternalTemp = 95
+	defaultSetpoint     = 90
+	defaultHumidity     = 65
+)
+
+// modes are handed out to each output in turn, so that every kind of output gets exercised
+var modes = []string{"Proportional Heating", "Proportional Heating", "Humidifying", "Timer"}
+
+// Config describes how the simulated device starts out. Everything except the number of outputs can be changed
+// later on via the [Device]'s methods.
+type Config struct {
+	// Nickname is the device's name. Defaults to "Herpstat Simulator".
+	Nickname string
+	// Outputs is the number of outputs. Defaults to 4, which is also used for anything less than 1.
+	Outputs int
+	// Drift is the most that any reading can change between polls. 0 keeps readings steady.
+	Drift float64
+	// InvalidJSONRate is the chance (0-1) that any response comes back corrupted.
+	InvalidJSONRate float64
+	// Delay is how long to wait before responding.
+	Delay time.Duration
+	// Seed seeds the random number generator, so that tests are repeatable.
+	Seed int64
+}
+
+// Device is a simulated Herpstat SpyderWeb
+type Device struct {
+	mu sync.Mutex
+
+	rand            *rand.Rand
+	drift           float64
+	invalidJSONRate float64
+	corruptNext     int
+	delay           time.Duration
+	polls           int
+
+	system  System
+	outputs []Output
+}
+
+// System is the "system" object in /RAWSTATUS
+type System struct {
+	Nickname     string  `json:"nickname"`
+	IP           string  `json:"ip"`
+	Mac          string  `json:"mac"`
+	Firmware     string  `json:"firmware"`
+	SafetyRelay  string  `json:"safetyrelay"`
+	Outputs      int     `json:"numberofoutputs"`
+	PowerResets  int     `json:"powerresets"`
+	InternalTemp float64 `json:"internaltemp"`
+}
+
+// Output is one of the "output#" objects in /RAWSTATUS
+type Output struct {
+	Nickname      string  `json:"outputnickname"`
+	Mode          string  `json:"outputmode"`
+	Ramping       string  `json:"ramping"`
+	ErrorDesc     string  `json:"errorcodedescription"`
+	Power         float64 `json:"poweroutput"`
+	PowerLimit    float64 `json:"poweroutputLIMIT"`
+	ProbeTemp     float64 `json:"probereadingTEMP,omitempty"`
+	ProbeHumidity float64 `json:"probereadingRH,omitempty"`
+	AlarmEnabled  int     `json:"enablehighlowalarm"`
+	AlarmHigh     float64 `json:"highalarm"`
+	AlarmLow      float64 `json:"lowalarm"`
+	Setpoint      float64 `json:"currentsetting"`
+	ErrorCode     int     `json:"errorcode"`
+
+	unplugged bool
+}
+
+// New creates a simulated device with healthy readings
+func New(c Config) *Device {
+	if c.Nickname == "" {
+		c.Nickname = defaultNickname
+	}
+
+	if c.Outputs < 1 {
+		c.Outputs = defaultOutputs
+	}
+
+	d := &Device{
+		rand:            rand.New(rand.NewSource(c.Seed)), //nolint:gosec // this doesn't need to be secure
+		drift:           c.Drift,
+		invalidJSONRate: c.InvalidJSONRate,
+		delay:           c.Delay,
+		system: System{
+			Nickname:     c.Nickname,
+			IP:           "192.168.1.50",
+			Mac:          "24:0A:C4:00:00:01",
+			Firmware:     "2.10",
+			SafetyRelay:  SafetyRelayOff,
+			Outputs:      c.Outputs,
+			InternalTemp: defaultInternalTemp,
+		},
+		outputs: make([]Output, c.Outputs),
+	}
+
+	for i := range d.outputs {
+		d.outputs[i] = newOutput(i+1, modes[i%len(modes)])
+	}
+
+	return d
+}
+
+func newOutput(id int, mode string) Output {
+	o := Output{
+		Nickname:   fmt.Sprintf("Output %d", id),
+		Mode:       mode,
+		Ramping:    rampingOff,
+		ErrorDesc:  noErrorDesc,
+		Power:      50,
+		PowerLimit: 100,
+	}
+
+	switch mode {
+	case "Humidifying":
+		o.ProbeHumidity = defaultHumidity
+		o.Setpoint = defaultHumidity
+		o.AlarmEnabled = 1
+		o.AlarmHigh = defaultHumidity + 20
+		o.AlarmLow = defaultHumidity - 20
+	case "Timer":
+		o.Power = 100
+	default:
+		o.ProbeTemp = defaultSetpoint
+		o.Setpoint = defaultSetpoint
+		o.AlarmEnabled = 1
+		o.AlarmHigh = defaultSetpoint + 5
+		o.AlarmLow = defaultSetpoint - 10
+	}
+
+	return o
+}
+
+// ServeHTTP serves /RAWSTATUS, moving the simulation forward by one poll each time
+func (d *Device) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != RawstatusPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	d.mu.Lock()
+	delay := d.delay
+	body := d.rawstatus()
+	d.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body) //nolint:errcheck // the client went away. there's nothing to do about it
+}
+
+// Polls returns the number of times that /RAWSTATUS has been requested
+func (d *Device) Polls() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.polls
+}
+
+// SetDrift changes the most that any reading can change between polls
+func (d *Device) SetDrift(drift float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.drift = drift
+}
+
+// SetDelay changes how long the device waits before responding
+func (d *Device) SetDelay(delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.delay = delay
+}
+
+// SetInvalidJSONRate changes the chance (0-1) that any response comes back corrupted
+func (d *Device) SetInvalidJSONRate(rate float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.invalidJSONRate = rate
+}
+
+// CorruptNext makes the next n responses come back as invalid JSON
+func (d *Device) CorruptNext(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.corruptNext = n
+}
+
+// UnplugProbe makes an output (numbered from 1) report garbage readings and a probe error, as if its probe was pulled
+func (d *Device) UnplugProbe(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.outputs[id-1].unplugged = true
+}
+
+// PlugProbe undoes [Device.UnplugProbe]
+func (d *Device) PlugProbe(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.outputs[id-1].unplugged = false
+}
+
+// TripSafetyRelay trips the safety relay with the given message, or [SafetyRelayTripped] if it's empty
+func (d *Device) TripSafetyRelay(message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if message == "" {
+		message = SafetyRelayTripped
+	}
+
+	d.system.SafetyRelay = message
+}
+
+// ResetSafetyRelay puts the safety relay back to normal operation
+func (d *Device) ResetSafetyRelay() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.system.SafetyRelay = SafetyRelayOff
+}
+
+// PowerReset simulates the device losing power and coming back up
+func (d *Device) PowerReset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.system.PowerResets++
+}
+
+// UpdateOutput makes arbitrary changes to an output (numbered from 1), eg: to change its mode or settings
+func (d *Device) UpdateOutput(id int, update func(o *Output)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	update(&d.outputs[id-1])
+}
+
+// rawstatus moves the simulation forward by one poll and returns the /RAWSTATUS body. d.mu must be held.
+func (d *Device) rawstatus() []byte {
+	d.polls++
+	d.step()
+
+	status := map[string]interface{}{"system": d.system}
+
+	for i := range d.outputs {
+		o := d.outputs[i]
+
+		if o.unplugged {
+			if o.ProbeHumidity != 0 {
+				o.ProbeHumidity = UnpluggedReading
+			} else {
+				o.ProbeTemp = UnpluggedReading
+			}
+
+			o.ErrorCode = UnpluggedErrorCode
+			o.ErrorDesc = UnpluggedErrorDesc
+		}
+
+		status[fmt.Sprintf("output%d", i+1)] = o
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		// everything in here is a plain value, so this can't happen
+		panic(err)
+	}
+
+	if d.corruptNext > 0 || (d.invalidJSONRate > 0 && d.rand.Float64() < d.invalidJSONRate) {
+		if d.corruptNext > 0 {
+			d.corruptNext--
+		}
+
+		return d.corrupt(body)
+	}
+
+	return body
+}
+
+// step lets every reading drift a little bit. d.mu must be held.
+func (d *Device) step() {
+	if d.drift == 0 {
+		return
+	}
+
+	d.system.InternalTemp += d.nudge()
+
+	for i := range d.outputs {
+		o := &d.outputs[i]
+
+		if o.ProbeTemp != 0 {
+			o.ProbeTemp += d.nudge()
+		}
+
+		if o.ProbeHumidity != 0 {
+			o.ProbeHumidity += d.nudge()
+		}
+	}
+}
+
+// nudge returns a random change between -drift and +drift. d.mu must be held.
+func (d *Device) nudge() float64 {
+	return (d.rand.Float64()*2 - 1) * d.drift
+}
+
+// corrupt mangles a response in the same way that a real device occasionally does, by cutting it off part way
+// through. d.mu must be held.
+func (d *Device) corrupt(body []byte) []byte {
+	return body[:d.rand.Intn(len(body)-1)+1]
+}
@@ -0,0 +1,171 @@
+package simulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// get requests /RAWSTATUS from the device and returns the decoded body, or an error if it isn't valid JSON
+func get(t *testing.T, d *Device) (map[string]json.RawMessage, error) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, RawstatusPath, http.NoBody))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var status map[string]json.RawMessage
+	err := json.Unmarshal(rec.Body.Bytes(), &status)
+
+	return status, err
+}
+
+func getOutput(t *testing.T, d *Device, key string) Output {
+	t.Helper()
+
+	status, err := get(t, d)
+	if err != nil {
+		t.Fatalf("unexpected invalid JSON: %s", err)
+	}
+
+	var o Output
+	if err := json.Unmarshal(status[key], &o); err != nil {
+		t.Fatalf("unable to unmarshal %s: %s", key, err)
+	}
+
+	return o
+}
+
+func TestRawstatus(t *testing.T) {
+	d := New(Config{Outputs: 3})
+
+	status, err := get(t, d)
+	if err != nil {
+		t.Fatalf("unexpected invalid JSON: %s", err)
+	}
+
+	for _, key := range []string{"system", "output1", "output2", "output3"} {
+		if _, ok := status[key]; !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+
+	if len(status) != 4 {
+		t.Errorf("expected 4 objects, got %d", len(status))
+	}
+
+	if d.Polls() != 1 {
+		t.Errorf("expected 1 poll, got %d", d.Polls())
+	}
+}
+
+func TestNegativeOutputs(t *testing.T) {
+	d := New(Config{Outputs: -1})
+
+	status, err := get(t, d)
+	if err != nil {
+		t.Fatalf("unexpected invalid JSON: %s", err)
+	}
+
+	var system System
+	if err := json.Unmarshal(status["system"], &system); err != nil {
+		t.Fatalf("unable to unmarshal system: %s", err)
+	}
+
+	if system.Outputs != defaultOutputs {
+		t.Errorf("expected %d outputs, got %d", defaultOutputs, system.Outputs)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestCorruptNext(t *testing.T) {
+	d := New(Config{})
+	d.CorruptNext(2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := get(t, d); err == nil {
+			t.Errorf("expected invalid JSON on poll %d", i+1)
+		}
+	}
+
+	if _, err := get(t, d); err != nil {
+		t.Errorf("expected valid JSON after corrupted polls: %s", err)
+	}
+}
+
+func TestUnplugProbe(t *testing.T) {
+	d := New(Config{})
+	d.UnplugProbe(1)
+
+	o := getOutput(t, d, "output1")
+	if o.ProbeTemp != UnpluggedReading || o.ErrorCode != UnpluggedErrorCode {
+		t.Errorf("expected an unplugged probe, got %v (error %d)", o.ProbeTemp, o.ErrorCode)
+	}
+
+	d.PlugProbe(1)
+
+	o = getOutput(t, d, "output1")
+	if o.ProbeTemp == UnpluggedReading || o.ErrorCode != 0 {
+		t.Errorf("expected a plugged in probe, got %v (error %d)", o.ProbeTemp, o.ErrorCode)
+	}
+}
+
+func TestSafetyRelay(t *testing.T) {
+	d := New(Config{})
+	d.TripSafetyRelay("")
+
+	status, err := get(t, d)
+	if err != nil {
+		t.Fatalf("unexpected invalid JSON: %s", err)
+	}
+
+	var s System
+	if err := json.Unmarshal(status["system"], &s); err != nil {
+		t.Fatalf("unable to unmarshal system: %s", err)
+	}
+
+	if s.SafetyRelay != SafetyRelayTripped {
+		t.Errorf("expected %q, got %q", SafetyRelayTripped, s.SafetyRelay)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	d := New(Config{Drift: 1, Seed: 1})
+
+	first := getOutput(t, d, "output1")
+	second := getOutput(t, d, "output1")
+
+	if first.ProbeTemp == second.ProbeTemp {
+		t.Errorf("expected the temperature to drift, but it stayed at %v", first.ProbeTemp)
+	}
+
+	if diff := second.ProbeTemp - first.ProbeTemp; diff > 1 || diff < -1 {
+		t.Errorf("expected the temperature to drift by at most 1, got %v", diff)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	d := New(Config{Delay: 50 * time.Millisecond})
+
+	started := time.Now()
+	if _, err := get(t, d); err != nil {
+		t.Fatalf("unexpected invalid JSON: %s", err)
+	}
+
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("expected a delay of at least 50ms, got %s", elapsed)
+	}
+}